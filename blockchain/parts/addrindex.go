@@ -0,0 +1,180 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	addrIndexName   = "addrindex"
+	addrIndexBucket = "addrindex"
+)
+
+func init() {
+	indexers[addrIndexName] = func() Indexer { return AddrIndex{} }
+}
+
+//AddrIndex keep transactions which pay to or spend from each public key hash
+//Key is pubKeyHash, 8 bytes height, 4 bytes position in block and 1 byte direction
+//so entries of an address are found by prefix scan in order of height
+//Value is transaction ID followed by 8 bytes amount
+type AddrIndex struct{}
+
+//AddressTx is a transaction in history of an address
+//Sent is true when the address spent Value, otherwise the address received Value
+type AddressTx struct {
+	TxID   []byte
+	Height int
+	Sent   bool
+	Value  int
+}
+
+//Name of the index
+func (AddrIndex) Name() string {
+	return addrIndexName
+}
+
+//Init clear the index
+func (AddrIndex) Init(tx StorageTx) error {
+	err := tx.DeleteBucket([]byte(addrIndexBucket))
+	if err != nil && err != ErrBucketNotFound {
+		return err
+	}
+
+	_, err = tx.CreateBucket([]byte(addrIndexBucket))
+	return err
+}
+
+//ConnectBlock add entries of every address which block pays to or spends from
+func (AddrIndex) ConnectBlock(tx StorageTx, block *Block) error {
+	ib := tx.Bucket([]byte(addrIndexBucket))
+
+	for key, value := range addrIndexEntries(tx, block) {
+		err := ib.Put([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DisconnectBlock remove entries of block
+func (AddrIndex) DisconnectBlock(tx StorageTx, block *Block) error {
+	ib := tx.Bucket([]byte(addrIndexBucket))
+
+	for key := range addrIndexEntries(tx, block) {
+		err := ib.Delete([]byte(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//addrIndexEntries make entries of block with amounts summed per address and direction
+//Spent outputs are read from undo data. Blocks stored before undo data have spends without amount
+func addrIndexEntries(tx StorageTx, block *Block) map[string][]byte {
+	var spentOutputs []TxOutput
+	entries := make(map[string][]byte)
+
+	if ub := tx.Bucket([]byte(undoBucket)); ub != nil {
+		if undoData := ub.Get(block.Hash); undoData != nil {
+			spentOutputs = DeserializeBlockUndo(undoData).SpentOutputs
+		}
+	}
+
+	spentIdx := 0
+	for position, transaction := range block.Transactions {
+		received := make(map[string]int)
+		sent := make(map[string]int)
+
+		if transaction.IsCoinbase() == false {
+			for _, vin := range transaction.Vin {
+				pubKeyHash := HashPubKey(vin.PubKey)
+				value := 0
+				if spentIdx < len(spentOutputs) {
+					pubKeyHash = spentOutputs[spentIdx].PubKeyHash
+					value = spentOutputs[spentIdx].Value
+				}
+				sent[string(pubKeyHash)] += value
+				spentIdx++
+			}
+		}
+		for _, out := range transaction.Vout {
+			received[string(out.PubKeyHash)] += out.Value
+		}
+
+		for pubKeyHash, value := range received {
+			key := addrIndexKey([]byte(pubKeyHash), block.Height, position, false)
+			entries[string(key)] = addrIndexValue(transaction.ID, value)
+		}
+		for pubKeyHash, value := range sent {
+			key := addrIndexKey([]byte(pubKeyHash), block.Height, position, true)
+			entries[string(key)] = addrIndexValue(transaction.ID, value)
+		}
+	}
+
+	return entries
+}
+
+func addrIndexKey(pubKeyHash []byte, height, position int, sent bool) []byte {
+	key := append([]byte{}, pubKeyHash...)
+	key = append(key, heightKey(height)...)
+
+	pos := make([]byte, 4)
+	binary.BigEndian.PutUint32(pos, uint32(position))
+	key = append(key, pos...)
+
+	if sent {
+		return append(key, 1)
+	}
+	return append(key, 0)
+}
+
+func addrIndexValue(txID []byte, value int) []byte {
+	amount := make([]byte, 8)
+	binary.BigEndian.PutUint64(amount, uint64(value))
+	return append(append([]byte{}, txID...), amount...)
+}
+
+//hasAddrIndex check address index is enabled
+func (bc *BlockChain) hasAddrIndex() bool {
+	for _, idx := range bc.indexers {
+		if idx.Name() == addrIndexName {
+			return true
+		}
+	}
+	return false
+}
+
+//AddressHistory return transactions of pubKeyHash in main chain ordered by height
+//Address index must be enabled
+func (bc *BlockChain) AddressHistory(pubKeyHash []byte) ([]AddressTx, error) {
+	var history []AddressTx
+
+	if !bc.hasAddrIndex() {
+		return nil, errors.New("Address index is not enabled")
+	}
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		c := tx.Bucket([]byte(addrIndexBucket)).Cursor()
+
+		for k, v := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, v = c.Next() {
+			rest := k[len(pubKeyHash):]
+			if len(rest) != 13 {
+				continue
+			}
+
+			history = append(history, AddressTx{
+				TxID:   append([]byte{}, v[:len(v)-8]...),
+				Height: int(binary.BigEndian.Uint64(rest[:8])),
+				Sent:   rest[12] == 1,
+				Value:  int(binary.BigEndian.Uint64(v[len(v)-8:])),
+			})
+		}
+		return nil
+	})
+
+	return history, err
+}
@@ -2,33 +2,37 @@ package parts
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 )
 
 //Block Define basic block struct
+//Hash is BlockHash of the header kept next to it
 type Block struct {
-	TimeStamp     int64
-	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        int
+	BlockHeader
+	Hash         []byte
+	Transactions []*Transaction
 }
 
 //NewBlock constructor Block
-func NewBlock(transactions []*Transaction, PrevBlockHash []byte, height int) *Block {
+//bits is compact target of proof of work
+func NewBlock(transactions []*Transaction, PrevBlockHash []byte, height int, bits uint32) *Block {
 	block := &Block{
-		TimeStamp:     time.Now().Unix(),
-		Transactions:  transactions,
-		PrevBlockHash: PrevBlockHash,
-		Hash:          []byte{},
-		Nonce:         0,
-		Height:        height,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: PrevBlockHash,
+			TimeStamp:     time.Now().Unix(),
+			Bits:          bits,
+			Nonce:         0,
+			Height:        height,
+		},
+		Hash:         []byte{},
+		Transactions: transactions,
 	}
+	block.MerkleRoot = block.HashTransactions()
+
 	pow := NewProofOfWork(block)
 	nonce, hash := pow.Run()
 
@@ -40,52 +44,71 @@ func NewBlock(transactions []*Transaction, PrevBlockHash []byte, height int) *Bl
 
 //NewGenesisBlock generate genesis block
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, initialBits)
 }
 
-//Serialize *Block to []byte
-func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-
-	//Transmit b to encoder
-	err := encoder.Encode(b)
+//Header return copy of block without transactions
+//Merkle root is enough to check proof of work and merkle proofs
+func (b *Block) Header() *Block {
+	header := *b
+	header.Transactions = nil
 
-	if err != nil {
-		fmt.Println("Serialize error. Check receiver block")
-		log.Panic(err)
-	}
+	return &header
+}
 
-	return result.Bytes()
+//Serialize *Block to []byte in canonical encoding
+func (b *Block) Serialize() []byte {
+	return encodeBlock(b)
 }
 
 //DeserializeBlock []byte to *Block
 func DeserializeBlock(d []byte) *Block {
-	var block Block
-
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	//read decoder and store it to block
-	err := decoder.Decode(&block)
-
+	block, err := decodeBlock(d)
 	if err != nil {
 		fmt.Println("Serialize error. Check input parameter")
 		log.Panic(err)
 	}
 
-	return &block
+	return block
 }
 
-//HashTransactions concatnate ID of transactions and put it into sha256
+//decodeBlock is DeserializeBlock which returns error of broken data
+func decodeBlock(d []byte) (*Block, error) {
+	return decodeCanonicalBlock(d)
+}
+
+//HashTransactions return merkle root of serialized transactions
+//Leaves include signatures because transaction ID does not cover them
 func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+	var transactions [][]byte
 
-	//https://www.dotnetperls.com/2d-go
-	//Good example to understand 2d slice append
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		transactions = append(transactions, tx.Serialize())
+	}
+	mTree := NewMerkleTree(transactions)
+
+	return mTree.RootNode.Data
+}
+
+//MerkleProof build inclusion proof of transaction in the block
+func (b *Block) MerkleProof(txID []byte) (*MerkleProof, error) {
+	var transactions [][]byte
+	index := -1
+
+	for i, tx := range b.Transactions {
+		if bytes.Compare(tx.ID, txID) == 0 {
+			index = i
+		}
+		transactions = append(transactions, tx.Serialize())
+	}
+	if index < 0 {
+		return nil, errors.New("Transaction is not in the block")
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
 
-	return txHash[:]
+	return NewMerkleTree(transactions).Proof(index), nil
+}
+
+//VerifyTransactionProof check tx is included in block which has merkle root
+func VerifyTransactionProof(root []byte, tx *Transaction, proof *MerkleProof) bool {
+	return proof.Verify(root, tx.Serialize())
 }
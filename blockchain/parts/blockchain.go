@@ -3,32 +3,79 @@ package parts
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
-
-	"github.com/boltdb/bolt"
 )
 
 const (
 	dbFile              = "blockchain.db"
 	blocksBucket        = "blocks"
+	chainWorkBucket     = "chainwork"
 	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 )
 
 //BlockChain chain of blocks
 //tip is hash of last chain
+//orphans are blocks whose parent is not received yet
+//listeners are called after main chain is changed
+//indexers are optional indexes enabled by EnableIndex
+//pruneDepth is number of recent blocks kept with transactions when pruning is enabled
+//legacyHeight is the highest block migrated from gob encoding
 type BlockChain struct {
-	Tip []byte
-	Db  *bolt.DB
+	Tip          []byte
+	Db           Storage
+	orphans      map[string]*Block
+	listeners    []func(*ChainUpdate)
+	indexers     []Indexer
+	pruneDepth   int
+	legacyHeight int
+}
+
+//ChainUpdate is blocks disconnected from and connected to main chain by AddBlock
+//Disconnected is ordered from old tip to fork point, Connected is ordered from fork point to new tip
+type ChainUpdate struct {
+	Disconnected []*Block
+	Connected    []*Block
 }
 
-//AddBlock add block
-func (bc *BlockChain) AddBlock(block *Block) {
-	err := bc.Db.Update(func(tx *bolt.Tx) error {
+//Subscribe register fn which is called with every change of main chain
+func (bc *BlockChain) Subscribe(fn func(*ChainUpdate)) {
+	bc.listeners = append(bc.listeners, fn)
+}
+
+//blockLookup find block by hash
+type blockLookup func(hash []byte) *Block
+
+//bucketLookup find block in blocks bucket
+func bucketLookup(b StorageBucket) blockLookup {
+	return func(hash []byte) *Block {
+		return DeserializeBlock(b.Get(hash))
+	}
+}
+
+//AddBlock validate and store block and move the tip to the branch with the most cumulative work
+//Context free checks run first and then checks against its parent.
+//Transactions are checked against UTXO set when the block is connected to main chain
+//Blocks whose parent is unknown are kept as orphans until the parent arrives
+//Subscribers are notified when main chain is changed, disconnected blocks of a reorganization are in ChainUpdate
+//Tip and orphans are changed only after the db transaction is committed
+func (bc *BlockChain) AddBlock(block *Block) error {
+	var update *ChainUpdate
+	var stored, orphan bool
+
+	err := CheckBlockSanity(block)
+	if err != nil {
+		return err
+	}
+
+	err = bc.Db.Update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
+		w := tx.Bucket([]byte(chainWorkBucket))
 		blockInDb := b.Get(block.Hash)
 
 		//already existed block
@@ -37,37 +84,131 @@ func (bc *BlockChain) AddBlock(block *Block) {
 			return nil
 		}
 
+		parentWork := w.Get(block.PrevBlockHash)
+		if parentWork == nil {
+			orphan = true
+			return nil
+		}
+
+		parent := DeserializeBlock(b.Get(block.PrevBlockHash))
+		err := checkBlockContext(bucketLookup(b), block, parent)
+		if err != nil {
+			return err
+		}
+
 		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
-		if err == nil {
+		err = b.Put(block.Hash, blockData)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		work := new(big.Int).SetBytes(parentWork)
+		work.Add(work, NewProofOfWork(block).Work())
+		err = w.Put(block.Hash, work.Bytes())
+		if err != nil {
 			log.Panic(err)
 		}
 
 		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		lastWork := new(big.Int).SetBytes(w.Get(lastHash))
 
-		//Only valid when block.Height is lastBlock.Height + 1
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
+		//Side branch does not have more work than main chain
+		if work.Cmp(lastWork) <= 0 {
+			stored = true
+			return nil
+		}
+
+		//Transactions are checked while UTXO set is updated
+		//Any error rolls back the whole db transaction including the block itself
+		UTXOSet := UTXOSet{bc}
+		if bytes.Compare(block.PrevBlockHash, lastHash) == 0 {
+			err = UTXOSet.connectBlock(tx, block)
+			update = &ChainUpdate{Connected: []*Block{block}}
+		} else {
+			disconnect, connect := findFork(b, lastHash, block)
+			update = &ChainUpdate{disconnect, connect}
+
+			//Pruned blocks have neither transactions nor undo data to disconnect
+			for _, old := range disconnect {
+				if old.IsPruned() {
+					return fmt.Errorf("Reorganization from height %d is deeper than prune window", old.Height)
+				}
+				if old.Height <= bc.legacyHeight {
+					return fmt.Errorf("Reorganization from height %d reaches blocks migrated from gob encoding", old.Height)
+				}
 			}
-			bc.Tip = block.Hash
+
+			fmt.Printf("Reorganize chain: disconnect %d blocks, connect %d blocks\n", len(disconnect), len(connect))
+			err = bc.disconnectIndexes(tx, disconnect)
+			if err == nil {
+				err = UTXOSet.reorganize(tx, disconnect, connect)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		err = updateHeightIndex(tx, update)
+		if err != nil {
+			return err
+		}
+
+		err = bc.connectIndexes(tx, update.Connected)
+		if err != nil {
+			return err
+		}
+
+		err = bc.pruneBlocks(tx)
+		if err != nil {
+			return err
 		}
+
+		err = b.Put([]byte("l"), block.Hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		stored = true
+
 		return nil
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	if orphan {
+		bc.addOrphan(block)
+		return nil
+	}
+	if !stored {
+		return nil
 	}
+	if update != nil {
+		bc.Tip = block.Hash
+	}
+	children := bc.takeOrphans(block.Hash)
+
+	if update != nil {
+		for _, fn := range bc.listeners {
+			fn(update)
+		}
+	}
+
+	for _, child := range children {
+		err := bc.AddBlock(child)
+		if err != nil {
+			fmt.Printf("Rejected orphan block %x: %s\n", child.Hash, err)
+		}
+	}
+
+	return nil
 }
 
 //GetBlock return block
 func (bc *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		blockData := b.Get(blockHash)
 
@@ -86,30 +227,24 @@ func (bc *BlockChain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-//GetBlockHashes return every block's hashes
-func (bc *BlockChain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
-	bci := bc.Iterator()
+//GetHeaders return headers of main chain blocks from fromHeight in order of height
+//At most max headers are returned
+func (bc *BlockChain) GetHeaders(fromHeight, max int) []*Block {
+	var headers []*Block
 
-	for {
-		block := bci.Next()
-		blocks = append(blocks, block.Hash)
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	for _, block := range bc.GetBlocksByHeight(fromHeight, fromHeight+max-1) {
+		headers = append(headers, block.Header())
 	}
-	return blocks
+	return headers
 }
 
 //GetBestHeight return height of last block
 func (bc *BlockChain) GetBestHeight() int {
-	var lastBlock Block
+	var lastHeight int
 
-	err := bc.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+		lastHeight = int(binary.BigEndian.Uint64(b.Get([]byte("h"))))
 
 		return nil
 	})
@@ -117,27 +252,33 @@ func (bc *BlockChain) GetBestHeight() int {
 		log.Panic(err)
 	}
 
-	return lastBlock.Height
+	return lastHeight
 }
 
 //MineBlock mine a new Block
-func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
+//Mined block is validated same as a block from other nodes
+func (bc *BlockChain) MineBlock(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
+	var bits uint32
 
+	//Inputs may come from other transactions in the block
+	//so they are checked with UTXO set after mining by AddBlock
 	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("ERROR: Invalid transaction")
+		err := CheckTransactionSanity(tx)
+		if err != nil {
+			return nil, err
 		}
 	}
-	err := bc.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-
-		blockData := b.Get(lastHash)
+		blockData := b.Get(b.Get([]byte("l")))
 		block := DeserializeBlock(blockData)
 
+		//Bytes from storage are valid only in the db transaction
+		lastHash = block.Hash
 		lastHeight = block.Height
+		bits = calcNextBits(block, bucketLookup(b))
 
 		return nil
 	})
@@ -145,56 +286,67 @@ func (bc *BlockChain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-
-	err = bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		bc.Tip = newBlock.Hash
-
-		return nil
-	})
+	newBlock := NewBlock(transactions, lastHash, lastHeight+1, bits)
+	err = bc.AddBlock(newBlock)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return newBlock
+
+	return newBlock, nil
 }
 
-//dbExists check there is a .db or not
-func dbExists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+//dbExists check there is a .db in data directory or not
+func dbExists(dir DataDir) bool {
+	if _, err := os.Stat(dir.ChainFile()); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
-//NewBlockChain creates new blockchain
-func NewBlockChain(address string) *BlockChain {
-	if dbExists() == false {
+//NewBlockChain load blockchain of data directory
+func NewBlockChain(dir DataDir) *BlockChain {
+	if dbExists(dir) == false {
 		fmt.Println("No existing blockchain found. Create one first.")
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := OpenBoltStorage(dir.ChainFile())
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	return NewBlockChainWithStorage(db)
+}
+
+//NewBlockChainWithStorage load blockchain kept in db
+func NewBlockChainWithStorage(db Storage) *BlockChain {
+	var tip []byte
+	legacyHeight := -1
+
+	err := db.Update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		if b == nil {
+			return errors.New("No existing blockchain found in storage")
+		}
+		//Bytes from storage are valid only in the db transaction
+		tip = append([]byte{}, b.Get([]byte("l"))...)
 		//bucket -> block -> tip
-		return nil
+
+		//Database created before canonical encoding keeps blocks in gob
+		err := migrateEncoding(tx)
+		if err != nil {
+			return err
+		}
+		legacyHeight = readLegacyHeight(tx)
+
+		//Database created before fork choice has no chain work
+		err = initChainWork(tx, tip)
+		if err != nil {
+			return err
+		}
+
+		//and database created before height index has no heights
+		return initHeightIndex(tx, tip)
 	})
 
 	if err != nil {
@@ -202,36 +354,57 @@ func NewBlockChain(address string) *BlockChain {
 	}
 
 	bc := BlockChain{
-		Tip: tip,
-		Db:  db,
+		Tip:          tip,
+		Db:           db,
+		orphans:      make(map[string]*Block),
+		legacyHeight: legacyHeight,
+	}
+
+	err = bc.loadPrune()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = bc.loadIndexes()
+	if err != nil {
+		log.Panic(err)
 	}
 	return &bc
 }
 
-//CreateBlockChain literally create new blockchain
-func CreateBlockChain(address string) *BlockChain {
-	if dbExists() {
+//CreateBlockChain literally create new blockchain in data directory
+func CreateBlockChain(address string, dir DataDir) *BlockChain {
+	if dbExists(dir) {
 		fmt.Println("BlockChain already exists")
 		os.Exit(1)
 	}
 
-	var tip []byte
-
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := OpenBoltStorage(dir.ChainFile())
 	if err != nil {
 		fmt.Println("Cannot open .db")
 		log.Panic(err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	return CreateBlockChainWithStorage(address, db)
+}
 
-		cbTx := NewCoinbaseTx(address, genesisCoinbaseData)
-		//Put cbTx to make a genesis block
-		genesis := NewGenesisBlock(cbTx)
+//CreateBlockChainWithStorage put genesis block to empty db
+func CreateBlockChainWithStorage(address string, db Storage) *BlockChain {
+	cbTx := NewCoinbaseTx(address, genesisCoinbaseData, 0, 0)
+	//Put cbTx to make a genesis block
+	genesis := NewGenesisBlock(cbTx)
 
+	return CreateBlockChainFromGenesis(genesis, db)
+}
+
+//CreateBlockChainFromGenesis put given genesis block to empty db
+func CreateBlockChainFromGenesis(genesis *Block, db Storage) *BlockChain {
+	var tip []byte
+
+	err := db.Update(func(tx StorageTx) error {
 		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		err = b.Put(genesis.Hash, genesis.Serialize())
@@ -245,7 +418,17 @@ func CreateBlockChain(address string) *BlockChain {
 		}
 		tip = genesis.Hash
 
-		return nil
+		err = setEncoding(tx)
+		if err != nil {
+			return err
+		}
+
+		err = initChainWork(tx, tip)
+		if err != nil {
+			return err
+		}
+
+		return initHeightIndex(tx, tip)
 	})
 
 	if err != nil {
@@ -253,8 +436,10 @@ func CreateBlockChain(address string) *BlockChain {
 	}
 
 	bc := BlockChain{
-		Tip: tip,
-		Db:  db,
+		Tip:          tip,
+		Db:           db,
+		orphans:      make(map[string]*Block),
+		legacyHeight: -1,
 	}
 	return &bc
 }
@@ -283,6 +468,7 @@ func (bc *BlockChain) FindUTXO() map[string]TxOutputs {
 
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXO[txID] = outs
 			}
 
@@ -303,12 +489,29 @@ func (bc *BlockChain) FindUTXO() map[string]TxOutputs {
 }
 
 //FindTransaction find transaction using ID
+//Transaction index is used when it is enabled
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	if bc.hasTxIndex() {
+		block, position, err := bc.findIndexedTransaction(ID)
+		if err != nil {
+			return Transaction{}, err
+		}
+		if block.IsPruned() {
+			return Transaction{}, ErrBlockPruned
+		}
+		return *block.Transactions[position], nil
+	}
+
 	bci := bc.Iterator()
 
 	for {
 		block := bci.Next()
 
+		//Transaction may have been in a pruned block
+		if block.IsPruned() {
+			return Transaction{}, ErrBlockPruned
+		}
+
 		for _, tx := range block.Transactions {
 			//Find only one transaction?
 			if bytes.Compare(tx.ID, ID) == 0 {
@@ -324,6 +527,32 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("Transation is not found")
 }
 
+//FindTransactionBlock find main chain block which includes transaction
+func (bc *BlockChain) FindTransactionBlock(ID []byte) (*Block, error) {
+	if bc.hasTxIndex() {
+		block, _, err := bc.findIndexedTransaction(ID)
+		return block, err
+	}
+
+	bci := bc.Iterator()
+
+	for {
+		block := bci.Next()
+
+		for _, tx := range block.Transactions {
+			if bytes.Compare(tx.ID, ID) == 0 {
+				return block, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return nil, errors.New("Transation is not found")
+}
+
 //SignTransaction set tx's signature using privkey
 func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
 	prevTxs := make(map[string]Transaction)
@@ -331,6 +560,15 @@ func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 	//If There are transations using Txid of vin
 	for _, vin := range tx.Vin {
 		prevTx, err := bc.FindTransaction(vin.Txid)
+		if err == ErrBlockPruned {
+			//Unspent output of pruned block is still in UTXO set
+			out, ok := UTXOSet{bc}.FindOutput(vin.Txid, vin.Vout)
+			if !ok {
+				log.Panic(err)
+			}
+			putPrevOutput(prevTxs, vin, out)
+			continue
+		}
 		if err != nil {
 			log.Panic(err)
 		}
@@ -340,19 +578,3 @@ func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 	//Set signature of tx using privKey and prevTxs
 	tx.Sign(privKey, prevTxs)
 }
-
-//VerifyTransaction find input transaction in blockchain(receiver) and verify it
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
-	prevTxs := make(map[string]Transaction)
-
-	for _, vin := range tx.Vin {
-		//bc.FindTransaction(vin.Txid) : find only 1 transaction
-		prevTx, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
-	}
-
-	return tx.Verify(prevTxs)
-}
@@ -0,0 +1,89 @@
+package parts
+
+import (
+	"bytes"
+	"testing"
+)
+
+//Side branch becomes main chain when it has more work, and its blocks replace blocks and outputs of old branch
+func TestAddBlockReorganizes(t *testing.T) {
+	source, others := testChains(t, 2, 1)
+	bc := others[0]
+
+	side := NewWallet()
+	sideBlock, err := bc.MineBlock([]*Transaction{NewCoinbaseTx(string(side.GetAddress()), "", 1, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates []*ChainUpdate
+	bc.Subscribe(func(update *ChainUpdate) {
+		updates = append(updates, update)
+	})
+
+	for height := 1; height <= 2; height++ {
+		block, err := source.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = bc.AddBlock(&block)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//Branch of same work does not replace main chain
+		if height == 1 && !bytes.Equal(bc.Tip, sideBlock.Hash) {
+			t.Fatal("Branch of same work becomes main chain")
+		}
+	}
+
+	if !bytes.Equal(bc.Tip, source.Tip) || bc.GetBestHeight() != 2 {
+		t.Fatalf("Tip is %x at height %d, expected %x", bc.Tip, bc.GetBestHeight(), source.Tip)
+	}
+	hash, _ := bc.GetBlockHash(1)
+	expected, _ := source.GetBlockHash(1)
+	if !bytes.Equal(hash, expected) {
+		t.Fatalf("Block %x is at height 1, expected %x", hash, expected)
+	}
+	if outs := (UTXOSet{bc}).FindUTXO(HashPubKey(side.PublicKey)); len(outs) != 0 {
+		t.Fatal("Outputs of disconnected block are kept")
+	}
+	if len(updates) != 1 || len(updates[0].Disconnected) != 1 || len(updates[0].Connected) != 2 {
+		t.Fatalf("Subscribers got %d updates, expected 1 reorganization", len(updates))
+	}
+}
+
+//Block rejected in db transaction changes neither tip nor orphans
+func TestAddBlockRejectedKeepsState(t *testing.T) {
+	_, others := testChains(t, 0, 1)
+	bc := others[0]
+	tip := bc.Tip
+
+	address := string(NewWallet().GetAddress())
+	spender := &Transaction{
+		Vin:  []TxInput{{Txid: bytes.Repeat([]byte{1}, 32), Vout: 0, PubKey: []byte{2}}},
+		Vout: []TxOutput{{Value: 1, PubKeyHash: []byte{3}}},
+	}
+	spender.SetID()
+	invalid := NewBlock([]*Transaction{NewCoinbaseTx(address, "", 1, 0), spender}, tip, 1, initialBits)
+	child := NewBlock([]*Transaction{NewCoinbaseTx(address, "", 2, 0)}, invalid.Hash, 2, initialBits)
+
+	err := bc.AddBlock(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bc.AddBlock(invalid)
+	if err == nil {
+		t.Fatal("Block spending unknown output is accepted")
+	}
+
+	if !bytes.Equal(bc.Tip, tip) {
+		t.Fatal("Tip is moved to rejected block")
+	}
+	if len(bc.orphans) != 1 {
+		t.Fatal("Orphan of rejected block is taken")
+	}
+	if _, err := bc.GetBlock(invalid.Hash); err == nil {
+		t.Fatal("Rejected block is stored")
+	}
+}
@@ -0,0 +1,201 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Bootstrap file is magic, 4 bytes version and blocks in order of height
+//Each block is 4 bytes big endian length followed by serialized block
+const (
+	bootstrapMagic       = "PBCB"
+	bootstrapVersion     = 2
+	maxBootstrapBlockLen = 32 * 1024 * 1024
+	bootstrapProgress    = 100
+)
+
+//ExportChain write main chain blocks from height from to height to in bootstrap format
+//to is cut at the tip, and negative to means the tip. It returns number of blocks written
+func (bc *BlockChain) ExportChain(w io.Writer, from, to int) (int, error) {
+	bestHeight := bc.GetBestHeight()
+	if to < 0 || to > bestHeight {
+		to = bestHeight
+	}
+	if from < 0 || from > to {
+		return 0, fmt.Errorf("Invalid height range %d - %d", from, to)
+	}
+
+	header := make([]byte, 8)
+	copy(header, bootstrapMagic)
+	binary.BigEndian.PutUint32(header[4:], bootstrapVersion)
+	_, err := w.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for height := from; height <= to; height += bootstrapProgress {
+		last := height + bootstrapProgress - 1
+		if last > to {
+			last = to
+		}
+
+		for _, block := range bc.GetBlocksByHeight(height, last) {
+			if block.IsPruned() {
+				return count, fmt.Errorf("Block at height %d is pruned", block.Height)
+			}
+			if block.Height <= bc.legacyHeight {
+				return count, fmt.Errorf("Block at height %d was migrated from gob encoding and cannot be validated by other nodes", block.Height)
+			}
+
+			err = writeBootstrapBlock(w, block)
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		fmt.Printf("Exported blocks up to height %d\n", last)
+	}
+
+	return count, nil
+}
+
+func writeBootstrapBlock(w io.Writer, block *Block) error {
+	return writeChunk(w, block.Serialize())
+}
+
+//writeChunk write 4 bytes big endian length and data
+func writeChunk(w io.Writer, data []byte) error {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+	_, err := w.Write(length)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+//readChunk read data written by writeChunk. Length must be between 1 and max
+//It returns io.EOF only when there is no more chunk
+func readChunk(r io.Reader, max uint32) ([]byte, error) {
+	length := make([]byte, 4)
+	_, err := io.ReadFull(r, length)
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Truncated file: %s", err)
+	}
+
+	n := binary.BigEndian.Uint32(length)
+	if n == 0 || n > max {
+		return nil, fmt.Errorf("Invalid chunk length %d", n)
+	}
+
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return nil, fmt.Errorf("Truncated file: %s", err)
+	}
+	return data, nil
+}
+
+//ReadBootstrap call fn with every block of bootstrap file in order
+func ReadBootstrap(r io.Reader, fn func(*Block) error) error {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return fmt.Errorf("Cannot read bootstrap header: %s", err)
+	}
+	if !bytes.Equal(header[:4], []byte(bootstrapMagic)) {
+		return errors.New("Not a bootstrap file")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != bootstrapVersion {
+		return fmt.Errorf("Unsupported bootstrap version %d", version)
+	}
+
+	for n := 0; ; n++ {
+		data, err := readChunk(r, maxBootstrapBlockLen)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		block, err := decodeBlock(data)
+		if err != nil {
+			return fmt.Errorf("block %d of bootstrap file: %v", n, err)
+		}
+		err = fn(block)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//ImportChain validate and add blocks of bootstrap file
+//Blocks already in chain are skipped, and every other block must follow a known block
+//UTXO set is updated block by block through AddBlock. It returns number of blocks added
+func (bc *BlockChain) ImportChain(r io.Reader) (int, error) {
+	count := 0
+
+	err := ReadBootstrap(r, func(block *Block) error {
+		if _, err := bc.GetBlock(block.Hash); err == nil {
+			return nil
+		}
+		if _, err := bc.GetBlock(block.PrevBlockHash); err != nil {
+			return fmt.Errorf("Parent of block %x at height %d is not found", block.Hash, block.Height)
+		}
+
+		err := bc.AddBlock(block)
+		if err != nil {
+			return fmt.Errorf("Block %x at height %d is invalid: %s", block.Hash, block.Height, err)
+		}
+
+		count++
+		if count%bootstrapProgress == 0 {
+			fmt.Printf("Imported blocks up to height %d\n", block.Height)
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+//ImportGenesis start empty db with the first block of bootstrap file
+//Rest of the file is imported by ImportChain
+func ImportGenesis(r io.Reader, db Storage) (*BlockChain, error) {
+	var genesis *Block
+	errStop := errors.New("stop")
+
+	err := ReadBootstrap(r, func(block *Block) error {
+		genesis = block
+		return errStop
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if genesis == nil {
+		return nil, errors.New("Bootstrap file has no block")
+	}
+
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 || genesis.Bits != initialBits {
+		return nil, errors.New("Bootstrap file does not start with genesis block")
+	}
+	err = CheckBlockSanity(genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := CreateBlockChainFromGenesis(genesis, db)
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	return bc, nil
+}
@@ -0,0 +1,34 @@
+package parts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//Corrupt block of bootstrap file is an error which tells the block, not a panic
+func TestReadBootstrapCorruptBlock(t *testing.T) {
+	bc, _ := testChains(t, 1, 0)
+
+	var file bytes.Buffer
+	_, err := bc.ExportChain(&file, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeChunk(&file, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := 0
+	err = ReadBootstrap(&file, func(*Block) error {
+		read++
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "block 2 of bootstrap file") {
+		t.Fatalf("Corrupt block gives error %v", err)
+	}
+	if read != 2 {
+		t.Fatalf("%d blocks are read before corrupt one, expected 2", read)
+	}
+}
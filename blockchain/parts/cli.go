@@ -1,10 +1,13 @@
 package parts
 
 import (
+	"bufio"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 )
 
@@ -15,21 +18,33 @@ func (cli *CLI) printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  dumptxoutset -out FILE -height HEIGHT - Write UTXO set at HEIGHT with headers to snapshot FILE and print its commitment. Default is the tip")
+	fmt.Println("  exportchain -out FILE -from HEIGHT -to HEIGHT - Write main chain blocks to bootstrap FILE. Whole chain by default")
+	fmt.Println("  getbalance -address ADDRESS -light - Get balance of ADDRESS. Verify it with headers and merkle proofs of a full node, when -light is set.")
+	fmt.Println("  getmempool -node NODE - Print transactions waiting in mempool of NODE. Default is the central node")
+	fmt.Println("  getmerkleproof -txid TXID - Print merkle proof that TXID is included in the blockchain")
+	fmt.Println("  history -address ADDRESS - Print transactions which paid to or spent from ADDRESS. Node must be started with -addrindex once")
+	fmt.Println("  importchain -in FILE - Validate and add blocks of bootstrap FILE. Blockchain is created from its genesis block when there is none")
+	fmt.Println("  loadtxoutset -in FILE -commitment HASH - Create blockchain from snapshot FILE. Reject it unless its commitment is HASH, when -commitment is set. Node validates the snapshot with blocks of the central node after start")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT -mine - Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set.")
-	fmt.Println("  startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE -mine -light - Send AMOUNT of coins from FROM address to TO and pay FEE to miner. Mine on the same node, when -mine is set. Spend outputs proven by a full node, when -light is set.")
+	fmt.Println("  supply -height HEIGHT - Print coins issued until HEIGHT. Check UTXO set against the schedule, when HEIGHT is the tip or not set")
+	fmt.Println("  verifychain -level LEVEL - Check stored blocks. 0 links and heights, 1 proof of work and merkle root, 2 signatures, 3 UTXO set. Default is 3")
+	fmt.Println("  startnode -miner ADDRESS -txindex -addrindex -prune N - Start a node with ID specified in NODE_ID env. var. -miner enables mining. -txindex and -addrindex enable transaction and address index. -prune keeps transactions of only the last N blocks. miner=ADDRESS, txindex=1, addrindex=1 and prune=N in node.conf work the same")
+	fmt.Println("Every command accepts -datadir DIR. Files of node are kept in " + DefaultDataDir("NODE_ID") + " by default")
+	fmt.Println("Every command accepts -network NAME of mainnet, testnet or regtest. Nodes of different networks reject messages of each other")
+	fmt.Println("emission=halving SUBSIDY INTERVAL or emission=curve S0,S1,... TAIL in node.conf replaces the schedule of halving 10 every 210 blocks. Every node of the network must use the same one")
 }
 
 //
-func (cli *CLI) createBlockChain(address string, nodeID string) {
+func (cli *CLI) createBlockChain(address string, dir DataDir) {
 	if !ValidateAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
-	bc := CreateBlockChain(address)
-	bc.Db.Close()
+	bc := CreateBlockChain(address, dir)
+	defer bc.Db.Close()
 
 	UTXOSet := UTXOSet{bc}
 	UTXOSet.Reindex()
@@ -38,20 +53,24 @@ func (cli *CLI) createBlockChain(address string, nodeID string) {
 }
 
 //
-func (cli *CLI) createWallet(nodeID string) {
-	wallets, _ := NewWallets(nodeID)
+func (cli *CLI) createWallet(dir DataDir) {
+	wallets, _ := NewWallets(dir)
 	address := wallets.CreateWallet()
-	wallets.SaveToFile(nodeID)
+	wallets.SaveToFile(dir)
 
 	fmt.Printf("Your new address : %s\n", address)
 }
 
 //
-func (cli *CLI) getBalance(address, nodeID string) {
+func (cli *CLI) getBalance(address, nodeID string, dir DataDir, light bool) {
 	if !ValidateAddress(address) {
 		log.Panic("ERROR : Address is not valid")
 	}
-	bc := NewBlockChain(nodeID)
+	if light {
+		cli.getLightBalance(address, nodeID, dir)
+		return
+	}
+	bc := NewBlockChain(dir)
 	UTXOSet := UTXOSet{bc}
 	defer bc.Db.Close()
 
@@ -67,8 +86,209 @@ func (cli *CLI) getBalance(address, nodeID string) {
 	fmt.Printf("Balance of '%s' : %d\n", address, balance)
 }
 
-func (cli *CLI) listAddresses(nodeID string) {
-	wallets, err := NewWallets(nodeID)
+//
+func (cli *CLI) getLightBalance(address, nodeID string, dir DataDir) {
+	lc := NewLightClient(nodeID, dir)
+	err := lc.Sync()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	balance, err := lc.GetBalance(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Balance of '%s' : %d\n", address, balance)
+}
+
+//
+func (cli *CLI) getMerkleProof(txID string, dir DataDir) {
+	ID, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	block, err := bc.FindTransactionBlock(ID)
+	if err != nil {
+		log.Panic(err)
+	}
+	proof, err := block.MerkleProof(ID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx := block.Transactions[proof.Index]
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Block: %x (height %d)\n", block.Hash, block.Height)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Index: %d\n", proof.Index)
+	fmt.Println("Path:")
+	for _, hash := range proof.Hashes {
+		fmt.Printf("  %x\n", hash)
+	}
+	fmt.Printf("Valid: %s\n", strconv.FormatBool(VerifyTransactionProof(block.MerkleRoot, tx, proof)))
+}
+
+//
+func (cli *CLI) history(address string, dir DataDir) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR : Address is not valid")
+	}
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+	history, err := bc.AddressHistory(pubKeyHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	received, sent := 0, 0
+	for _, entry := range history {
+		if entry.Sent {
+			sent += entry.Value
+			fmt.Printf("%x height %d sent %d\n", entry.TxID, entry.Height, entry.Value)
+		} else {
+			received += entry.Value
+			fmt.Printf("%x height %d received %d\n", entry.TxID, entry.Height, entry.Value)
+		}
+	}
+	fmt.Printf("Received %d, sent %d, balance %d\n", received, sent, received-sent)
+}
+
+//
+func (cli *CLI) exportChain(out string, from, to int, dir DataDir) {
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	file, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	count, err := bc.ExportChain(writer, from, to)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! %d blocks are exported to %s\n", count, out)
+}
+
+//
+func (cli *CLI) dumpTxOutSet(out string, height int, dir DataDir) {
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	file, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	commitment, err := bc.DumpUTXOSet(writer, height)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! Snapshot is written to %s\n", out)
+	fmt.Printf("Commitment: %x\n", commitment)
+}
+
+//
+func (cli *CLI) loadTxOutSet(in, commitment string, dir DataDir) {
+	var expected []byte
+	if commitment != "" {
+		var err error
+		expected, err = hex.DecodeString(commitment)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if dbExists(dir) {
+		fmt.Println("BlockChain already exists")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	db, err := OpenBoltStorage(dir.ChainFile())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc, err := LoadUTXOSnapshot(bufio.NewReader(file), db, expected)
+	if err != nil {
+		db.Close()
+		os.Remove(dir.ChainFile())
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	defer bc.Db.Close()
+
+	UTXOSet := UTXOSet{bc}
+	fmt.Printf("Done! Chain starts at height %d with %d transactions in the UTXO set\n", bc.GetBestHeight(), UTXOSet.CountTransactions())
+	fmt.Printf("Commitment: %x\n", UTXOSet.Commitment())
+}
+
+//
+func (cli *CLI) importChain(in string, dir DataDir) {
+	file, err := os.Open(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	var bc *BlockChain
+	if dbExists(dir) {
+		bc = NewBlockChain(dir)
+	} else {
+		db, err := OpenBoltStorage(dir.ChainFile())
+		if err != nil {
+			log.Panic(err)
+		}
+
+		bc, err = ImportGenesis(bufio.NewReader(file), db)
+		if err != nil {
+			db.Close()
+			os.Remove(dir.ChainFile())
+			log.Panic(err)
+		}
+
+		_, err = file.Seek(0, 0)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	defer bc.Db.Close()
+
+	count, err := bc.ImportChain(bufio.NewReader(file))
+	fmt.Printf("%d blocks are imported. Height of chain is %d\n", count, bc.GetBestHeight())
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Done!")
+}
+
+func (cli *CLI) listAddresses(dir DataDir) {
+	wallets, err := NewWallets(dir)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -80,8 +300,8 @@ func (cli *CLI) listAddresses(nodeID string) {
 }
 
 //
-func (cli *CLI) printChain(nodeID string) {
-	bc := NewBlockChain(nodeID)
+func (cli *CLI) printChain(dir DataDir) {
+	bc := NewBlockChain(dir)
 	defer bc.Db.Close()
 
 	bci := bc.Iterator()
@@ -93,6 +313,9 @@ func (cli *CLI) printChain(nodeID string) {
 		fmt.Printf("============ Block %x ============\n", block.Hash)
 		fmt.Printf("Prev. hash: %x\t\n", block.PrevBlockHash)
 		fmt.Printf("Hash: %x\t\n", block.Hash)
+		fmt.Printf("Version: %d\t\n", block.Version)
+		fmt.Printf("Bits: %08x\t\n", block.Bits)
+		fmt.Printf("Merkle root: %x\t\n", block.MerkleRoot)
 		pow := NewProofOfWork(block)
 		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
 		for _, tx := range block.Transactions {
@@ -107,41 +330,68 @@ func (cli *CLI) printChain(nodeID string) {
 }
 
 //
-func (cli *CLI) send(from, to string, amount int, nodeID string, mineNow bool) {
+func (cli *CLI) send(from, to string, amount, fee int, nodeID string, dir DataDir, mineNow, light bool) {
 	if !ValidateAddress(from) {
 		log.Panic("ERROR: Sender address is not valid")
 	}
 	if !ValidateAddress(to) {
 		log.Panic("ERROR: Recipient address is not valid")
 	}
-	bc := NewBlockChain(nodeID)
+	if light {
+		cli.sendLight(from, to, amount, fee, nodeID, dir)
+		return
+	}
+	bc := NewBlockChain(dir)
 	UTXOSet := UTXOSet{bc}
 	defer bc.Db.Close()
 
-	wallets, err := NewWallets(nodeID)
+	wallets, err := NewWallets(dir)
 	if err != nil {
 		log.Panic(err)
 	}
 	wallet := wallets.GetWallet(from)
 
-	tx := NewUTXOTransaction(&wallet, to, amount, &UTXOSet)
+	tx := NewUTXOTransaction(&wallet, to, amount, fee, &UTXOSet)
 
 	if mineNow {
-		cbTx := NewCoinbaseTx(from, "")
+		cbTx := NewCoinbaseTx(from, "", bc.GetBestHeight()+1, fee)
 		txs := []*Transaction{cbTx, tx}
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		_, err := bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
-		sendTx(knownNodes[0], tx)
+		node := NewNode(nodeID, "", dir)
+		node.submitTx(node.centralNode(), tx)
+	}
+
+	fmt.Println("Success!")
+}
+
+//
+func (cli *CLI) sendLight(from, to string, amount, fee int, nodeID string, dir DataDir) {
+	lc := NewLightClient(nodeID, dir)
+	err := lc.Sync()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	_, err = lc.Send(from, to, amount, fee)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	fmt.Println("Success!")
 }
 
 //
-func (cli *CLI) reindexUTXO(nodeID string) {
-	bc := NewBlockChain(nodeID)
+func (cli *CLI) reindexUTXO(dir DataDir) {
+	bc := NewBlockChain(dir)
+	if bc.PrunedHeight() >= 0 {
+		fmt.Println("ERROR: UTXO set cannot be rebuilt from pruned blocks")
+		os.Exit(1)
+	}
 	UTXOSet := UTXOSet{bc}
 	UTXOSet.Reindex()
 
@@ -150,7 +400,97 @@ func (cli *CLI) reindexUTXO(nodeID string) {
 }
 
 //
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+func (cli *CLI) supply(height int, dir DataDir) {
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	bestHeight := bc.GetBestHeight()
+	if height < 0 {
+		height = bestHeight
+	}
+
+	fmt.Printf("Scheduled supply at height %d : %d\n", height, TotalSupply(Emission, height))
+	fmt.Printf("Subsidy of block %d : %d\n", height, Emission.Subsidy(height))
+
+	if height != bestHeight {
+		return
+	}
+
+	UTXOSet := UTXOSet{bc}
+	_, actual, err := UTXOSet.CheckSupply()
+	fmt.Printf("UTXO set holds : %d\n", actual)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("UTXO set is within the schedule")
+}
+
+//
+func (cli *CLI) getMempool(node, nodeID string) {
+	client := NewNode(nodeID, "", "")
+	if node == "" {
+		node = client.centralNode()
+	}
+
+	entries, err := client.requestMempool(node)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("%d transactions in mempool of %s\n", len(entries), node)
+	for _, entry := range entries {
+		fmt.Printf("%x fee %d size %d time %d\n", entry.TxID, entry.Fee, entry.Size, entry.Time)
+		for _, parent := range entry.Depends {
+			fmt.Printf("    depends on %x\n", parent)
+		}
+	}
+}
+
+//
+func (cli *CLI) verifyChain(level int, dir DataDir) {
+	bc := NewBlockChain(dir)
+	defer bc.Db.Close()
+
+	problems := bc.VerifyChain(level)
+	for _, problem := range problems {
+		fmt.Printf("ERROR: %s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("%d problems are found\n", len(problems))
+		os.Exit(1)
+	}
+	if prunedHeight := bc.PrunedHeight(); prunedHeight >= 0 {
+		fmt.Printf("Blocks up to height %d are pruned. Only their headers are checked\n", prunedHeight)
+	}
+	fmt.Printf("No problem is found at level %d. Height of chain is %d\n", level, bc.GetBestHeight())
+}
+
+//
+func (cli *CLI) startNode(nodeID, minerAddress string, dir DataDir, txIndex, addrIndex bool, prune int) {
+	var indexes []string
+
+	config, err := dir.LoadConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+	if minerAddress == "" {
+		minerAddress = config["miner"]
+	}
+	if txIndex || config["txindex"] == "1" {
+		indexes = append(indexes, txIndexName)
+	}
+	if addrIndex || config["addrindex"] == "1" {
+		indexes = append(indexes, addrIndexName)
+	}
+	if prune == 0 && config["prune"] != "" {
+		prune, err = strconv.Atoi(config["prune"])
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
 	fmt.Printf("Starting node %s\n", nodeID)
 	if len(minerAddress) > 0 {
 		if ValidateAddress(minerAddress) {
@@ -159,7 +499,7 @@ func (cli *CLI) startNode(nodeID, minerAddress string) {
 			log.Panic("Wrong miner address!")
 		}
 	}
-	StartServer(nodeID, minerAddress)
+	NewNode(nodeID, minerAddress, dir).StartServer(indexes, prune)
 }
 
 func (cli *CLI) validateArgs() {
@@ -173,40 +513,96 @@ func (cli *CLI) validateArgs() {
 func (cli *CLI) Run() {
 	cli.validateArgs()
 
-	os.Setenv("NODE_ID", "168.0.0.1:3000")
 	nodeID := os.Getenv("NODE_ID")
 	if nodeID == "" {
 		fmt.Printf("NODE_ID env. var is not set!\n")
 		os.Exit(1)
 	}
 
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	getMempoolCmd := flag.NewFlagSet("getmempool", flag.ExitOnError)
+	getMerkleProofCmd := flag.NewFlagSet("getmerkleproof", flag.ExitOnError)
 	createBlockChainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
 
 	//String(name, value, usage)
 	//name : when it is called
 	//value : default value
 	//usage : output of help
+	dumpTxOutSetOut := dumpTxOutSetCmd.String("out", "", "File to write snapshot to")
+	dumpTxOutSetHeight := dumpTxOutSetCmd.Int("height", -1, "Height of snapshot block. Default is the tip")
+	loadTxOutSetIn := loadTxOutSetCmd.String("in", "", "Snapshot file to read UTXO set from")
+	loadTxOutSetCommitment := loadTxOutSetCmd.String("commitment", "", "Expected commitment of snapshot in hex")
+	exportChainOut := exportChainCmd.String("out", "", "File to write blocks to")
+	exportChainFrom := exportChainCmd.Int("from", 0, "Height of the first block")
+	exportChainTo := exportChainCmd.Int("to", -1, "Height of the last block. Default is the tip")
+	importChainIn := importChainCmd.String("in", "", "Bootstrap file to read blocks from")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	getBalanceLight := getBalanceCmd.Bool("light", false, "Verify balance with headers and merkle proofs of a full node")
+	getMempoolNode := getMempoolCmd.String("node", "", "Address of node to query")
+	getMerkleProofTxID := getMerkleProofCmd.String("txid", "", "ID of transaction to prove")
 	createBlockChainAddress := createBlockChainCmd.String("address", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendLight := sendCmd.Bool("light", false, "Spend outputs proven by a full node")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeTxIndex := startNodeCmd.Bool("txindex", false, "Keep index of every transaction. It stays enabled in later runs")
+	startNodeAddrIndex := startNodeCmd.Bool("addrindex", false, "Keep history of every address. It stays enabled in later runs")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Keep transactions of only the last N blocks. It stays enabled in later runs")
+	historyAddress := historyCmd.String("address", "", "The address to print history of")
+	supplyHeight := supplyCmd.Int("height", -1, "Height to calculate supply at")
+	verifyChainLevel := verifyChainCmd.Int("level", VerifyUTXO, "How thorough the check is")
+
+	//Every command accepts -datadir and -network
+	dataDirs := make(map[*flag.FlagSet]*string)
+	networkNames := make(map[*flag.FlagSet]*string)
+	for _, cmd := range []*flag.FlagSet{dumpTxOutSetCmd, exportChainCmd, getBalanceCmd, getMempoolCmd, getMerkleProofCmd, createBlockChainCmd, createWalletCmd,
+		historyCmd, importChainCmd, listAddressesCmd, loadTxOutSetCmd, printChainCmd, reindexUTXOCmd, sendCmd, startNodeCmd, supplyCmd, verifyChainCmd} {
+		dataDirs[cmd] = cmd.String("datadir", "", "Directory of chain, wallet, peers, config and log. Default is "+DefaultDataDir("NODE_ID"))
+		networkNames[cmd] = cmd.String("network", MainNet.Name, "Network to join: mainnet, testnet or regtest")
+	}
 
 	switch os.Args[1] {
+	case "dumptxoutset":
+		err := dumpTxOutSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "getbalance":
 		err := getBalanceCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getmempool":
+		err := getMempoolCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmerkleproof":
+		err := getMerkleProofCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "createblockchain":
 		err := createBlockChainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -217,11 +613,26 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "history":
+		err := historyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "listaddresses":
 		err := listAddressesCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "loadtxoutset":
+		err := loadTxOutSetCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "printchain":
 		err := printChainCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -242,25 +653,118 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "supply":
+		err := supplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
+	for cmd, name := range networkNames {
+		if cmd.Parsed() {
+			err := SelectNetwork(*name)
+			if err != nil {
+				log.Panic(err)
+			}
+		}
+	}
+
+	//Other networks keep their files apart from mainnet
+	dataDirPath := DefaultDataDir(nodeID)
+	if ActiveNetwork() != MainNet {
+		dataDirPath = filepath.Join(dataDirPath, ActiveNetwork().Name)
+	}
+	for cmd, path := range dataDirs {
+		if cmd.Parsed() && *path != "" {
+			dataDirPath = *path
+		}
+	}
+	dir, err := NewDataDir(dataDirPath)
+	if err != nil {
+		log.Panic(err)
+	}
+	//Older versions kept files of every node in working directory, and had no other network
+	if ActiveNetwork() == MainNet {
+		err = dir.MoveLegacyFiles(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	//Every command creating or checking coins uses the schedule, so it is read before any of them
+	config, err := dir.LoadConfig()
+	if err != nil {
+		log.Panic(err)
+	}
+	if spec := config["emission"]; spec != "" {
+		Emission, err = ParseEmission(spec)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
 	if createBlockChainCmd.Parsed() {
 		if *createBlockChainAddress == "" {
 			createBlockChainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockChain(*createBlockChainAddress, nodeID)
+		cli.createBlockChain(*createBlockChainAddress, dir)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeID)
+		cli.createWallet(dir)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainOut == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(*exportChainOut, *exportChainFrom, *exportChainTo, dir)
+	}
+
+	if dumpTxOutSetCmd.Parsed() {
+		if *dumpTxOutSetOut == "" {
+			dumpTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpTxOutSet(*dumpTxOutSetOut, *dumpTxOutSetHeight, dir)
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if *loadTxOutSetIn == "" {
+			loadTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadTxOutSet(*loadTxOutSetIn, *loadTxOutSetCommitment, dir)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainIn == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(*importChainIn, dir)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress, dir)
 	}
 
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeID)
+		cli.listAddresses(dir)
 	}
 
 	if getBalanceCmd.Parsed() {
@@ -268,23 +772,48 @@ func (cli *CLI) Run() {
 			getBalanceCmd.Usage()
 			os.Exit(1)
 		}
-		cli.getBalance(*getBalanceAddress, nodeID)
+		cli.getBalance(*getBalanceAddress, nodeID, dir, *getBalanceLight)
+	}
+
+	if getMempoolCmd.Parsed() {
+		cli.getMempool(*getMempoolNode, nodeID)
+	}
+
+	if getMerkleProofCmd.Parsed() {
+		if *getMerkleProofTxID == "" {
+			getMerkleProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getMerkleProof(*getMerkleProofTxID, dir)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain(nodeID)
+		cli.printChain(dir)
 	}
 
 	if reindexUTXOCmd.Parsed() {
-		cli.reindexUTXO(nodeID)
+		cli.reindexUTXO(dir)
 	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeID, *sendMine)
+		if *sendMine && *sendLight {
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, nodeID, dir, *sendMine, *sendLight)
+	}
+
+	if supplyCmd.Parsed() {
+		cli.supply(*supplyHeight, dir)
+	}
+
+	if verifyChainCmd.Parsed() {
+		cli.verifyChain(*verifyChainLevel, dir)
 	}
 
 	//
@@ -294,6 +823,6 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		cli.startNode(nodeID, *startNodeMiner, dir, *startNodeTxIndex, *startNodeAddrIndex, *startNodePrune)
 	}
 }
@@ -0,0 +1,157 @@
+package parts
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dataDirRoot = "nodes"
+	peersFile   = "peers.dat"
+	configFile  = "node.conf"
+	logFile     = "node.log"
+)
+
+//DataDir is a directory which keeps every file of a node
+//Nodes with different data directories can run from the same working directory
+type DataDir string
+
+//DefaultDataDir return data directory of node ID under working directory
+func DefaultDataDir(nodeID string) string {
+	return filepath.Join(dataDirRoot, nodeID)
+}
+
+//NewDataDir create the directory when it does not exist
+func NewDataDir(path string) (DataDir, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return "", err
+	}
+	return DataDir(path), nil
+}
+
+//MoveLegacyFiles move chain db and wallet which older versions kept in working directory into d
+//Files already in d are never replaced. Wallet was saved with node ID appended to its name by mistake
+func (d DataDir) MoveLegacyFiles(nodeID string) error {
+	legacy := []struct {
+		from string
+		to   string
+	}{
+		{dbFile, d.ChainFile()},
+		{walletFile + "%!(EXTRA string=" + nodeID + ")", d.WalletFile()},
+		{walletFile, d.WalletFile()},
+	}
+
+	for _, file := range legacy {
+		if _, err := os.Stat(file.from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(file.to); err == nil {
+			fmt.Printf("%s of older version is left in working directory because %s exists\n", file.from, file.to)
+			continue
+		}
+
+		err := os.Rename(file.from, file.to)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Moved %s of older version to %s\n", file.from, file.to)
+	}
+
+	return nil
+}
+
+//ChainFile is path of blockchain db
+func (d DataDir) ChainFile() string {
+	return filepath.Join(string(d), dbFile)
+}
+
+//WalletFile is path of wallets
+func (d DataDir) WalletFile() string {
+	return filepath.Join(string(d), walletFile)
+}
+
+//HeadersFile is path of headers of light client
+func (d DataDir) HeadersFile() string {
+	return filepath.Join(string(d), headersFile)
+}
+
+//PeersFile is path of known nodes
+func (d DataDir) PeersFile() string {
+	return filepath.Join(string(d), peersFile)
+}
+
+//ConfigFile is path of node options
+func (d DataDir) ConfigFile() string {
+	return filepath.Join(string(d), configFile)
+}
+
+//LogFile is path of node log
+func (d DataDir) LogFile() string {
+	return filepath.Join(string(d), logFile)
+}
+
+//LoadPeers return nodes saved by SavePeers
+//It returns nil when there is no peers file
+func (d DataDir) LoadPeers() ([]string, error) {
+	content, err := ioutil.ReadFile(d.PeersFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			peers = append(peers, line)
+		}
+	}
+	return peers, nil
+}
+
+//SavePeers write one node address per line
+func (d DataDir) SavePeers(peers []string) error {
+	content := strings.Join(peers, "\n") + "\n"
+	return ioutil.WriteFile(d.PeersFile(), []byte(content), 0644)
+}
+
+//Config is options of node read from node.conf
+//Each line is key=value, and lines beginning with # are comments
+type Config map[string]string
+
+//LoadConfig read node.conf of data directory
+//Config is empty when there is no file
+func (d DataDir) LoadConfig() (Config, error) {
+	config := make(Config)
+
+	file, err := os.Open(d.ConfigFile())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key=value", d.ConfigFile(), lineNo)
+		}
+		config[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return config, scanner.Err()
+}
@@ -0,0 +1,95 @@
+package parts
+
+import (
+	"math/big"
+)
+
+const (
+	initialTargetBits  = 16
+	minTargetBits      = 8
+	retargetInterval   = 20
+	targetBlockSpacing = 10
+	maxRetargetFactor  = 4
+)
+
+//powLimit is the easiest target a block can use
+var powLimit = new(big.Int).Lsh(big.NewInt(1), 256-minTargetBits)
+
+//initialBits is compact target of genesis block
+var initialBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-initialTargetBits))
+
+//CompactToBig convert compact target to big.Int
+//Compact target is 1 byte exponent(length in bytes) + 3 bytes mantissa
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		return big.NewInt(int64(mantissa))
+	}
+
+	target := big.NewInt(int64(mantissa))
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+//BigToCompact convert target to compact form
+//Precision lower than 3 bytes of mantissa is dropped
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		shifted := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(shifted.Uint64())
+	}
+
+	//0x00800000 is a sign bit so move one byte to exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+//calcNextBits return compact target the child of parent must use
+//Every retargetInterval blocks the target is scaled by actual time / expected time of last blocks
+func calcNextBits(parent *Block, lookup blockLookup) uint32 {
+	if (parent.Height+1)%retargetInterval != 0 {
+		return parent.Bits
+	}
+
+	first := parent
+	for i := 0; i < retargetInterval && len(first.PrevBlockHash) != 0; i++ {
+		first = lookup(first.PrevBlockHash)
+	}
+
+	expected := int64(parent.Height-first.Height) * targetBlockSpacing
+	actual := parent.TimeStamp - first.TimeStamp
+
+	//Clamp to avoid huge jump by few blocks with strange timestamps
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	target := CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+
+	return BigToCompact(target)
+}
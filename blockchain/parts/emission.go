@@ -0,0 +1,134 @@
+package parts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//EmissionSchedule decide how many new coins a block at height can mint
+type EmissionSchedule interface {
+	Subsidy(height int) int
+}
+
+//HalvingSchedule halve subsidy every Interval blocks
+type HalvingSchedule struct {
+	InitialSubsidy int
+	Interval       int
+}
+
+//NewHalvingSchedule return schedule halving initialSubsidy every interval blocks
+func NewHalvingSchedule(initialSubsidy, interval int) (HalvingSchedule, error) {
+	if initialSubsidy < 0 || interval <= 0 {
+		return HalvingSchedule{}, fmt.Errorf("Invalid halving schedule: subsidy %d, interval %d", initialSubsidy, interval)
+	}
+
+	return HalvingSchedule{initialSubsidy, interval}, nil
+}
+
+//Subsidy of block at height
+//Schedule without positive Interval mints nothing instead of dividing by zero
+func (s HalvingSchedule) Subsidy(height int) int {
+	if s.Interval <= 0 {
+		return 0
+	}
+
+	halvings := uint(height / s.Interval)
+
+	//Shifting more than bits of int is always zero
+	if halvings >= 63 {
+		return 0
+	}
+	return s.InitialSubsidy >> halvings
+}
+
+//CurveSchedule use Subsidies[height] and Tail after the curve ends
+type CurveSchedule struct {
+	Subsidies []int
+	Tail      int
+}
+
+//NewCurveSchedule return schedule of subsidies by height followed by tail
+func NewCurveSchedule(subsidies []int, tail int) (CurveSchedule, error) {
+	for height, subsidy := range subsidies {
+		if subsidy < 0 {
+			return CurveSchedule{}, fmt.Errorf("Invalid curve schedule: subsidy %d at height %d", subsidy, height)
+		}
+	}
+	if tail < 0 {
+		return CurveSchedule{}, fmt.Errorf("Invalid curve schedule: tail %d", tail)
+	}
+
+	return CurveSchedule{subsidies, tail}, nil
+}
+
+//Subsidy of block at height
+func (s CurveSchedule) Subsidy(height int) int {
+	if height < len(s.Subsidies) {
+		return s.Subsidies[height]
+	}
+	return s.Tail
+}
+
+//Emission is the schedule every node must agree on
+//Simulations can replace it before a blockchain is created, or with emission of node.conf
+var Emission EmissionSchedule = HalvingSchedule{InitialSubsidy: 10, Interval: 210}
+
+//ParseEmission read schedule written as "halving SUBSIDY INTERVAL" or "curve S0,S1,... TAIL"
+func ParseEmission(spec string) (EmissionSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("Invalid emission %q: expected halving SUBSIDY INTERVAL or curve S0,S1,... TAIL", spec)
+	}
+
+	last, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid emission %q: %s", spec, err)
+	}
+
+	switch fields[0] {
+	case "halving":
+		initialSubsidy, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid emission %q: %s", spec, err)
+		}
+		return NewHalvingSchedule(initialSubsidy, last)
+	case "curve":
+		var subsidies []int
+		for _, field := range strings.Split(fields[1], ",") {
+			subsidy, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid emission %q: %s", spec, err)
+			}
+			subsidies = append(subsidies, subsidy)
+		}
+		return NewCurveSchedule(subsidies, last)
+	}
+
+	return nil, fmt.Errorf("Invalid emission %q: unknown schedule %s", spec, fields[0])
+}
+
+//TotalSupply return coins minted by blocks from genesis to height
+func TotalSupply(schedule EmissionSchedule, height int) int {
+	supply := 0
+
+	for h := 0; h <= height; h++ {
+		supply += schedule.Subsidy(h)
+	}
+
+	return supply
+}
+
+//CheckSupply compare coins in UTXO set with the schedule at the tip
+//UTXO set may hold less because coinbase can claim less than allowed
+func (u UTXOSet) CheckSupply() (int, int, error) {
+	height := u.BlockChain.GetBestHeight()
+	scheduled := TotalSupply(Emission, height)
+	actual := u.TotalValue()
+
+	if actual > scheduled {
+		return scheduled, actual, fmt.Errorf("UTXO set holds %d coins but schedule allows %d at height %d", actual, scheduled, height)
+	}
+
+	return scheduled, actual, nil
+}
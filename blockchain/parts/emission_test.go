@@ -0,0 +1,29 @@
+package parts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEmission(t *testing.T) {
+	schedules := map[string]EmissionSchedule{
+		"halving 50 100":  HalvingSchedule{50, 100},
+		"curve 5,4,3 1":   CurveSchedule{[]int{5, 4, 3}, 1},
+		" halving  1  2 ": HalvingSchedule{1, 2},
+	}
+	for spec, expected := range schedules {
+		schedule, err := ParseEmission(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(schedule, expected) {
+			t.Fatalf("%q is parsed to %+v, expected %+v", spec, schedule, expected)
+		}
+	}
+
+	for _, spec := range []string{"", "halving 10", "halving 10 0", "halving -1 10", "curve 1,-1 0", "curve 1,x 0", "linear 1 2"} {
+		if _, err := ParseEmission(spec); err == nil {
+			t.Fatalf("%q is accepted", spec)
+		}
+	}
+}
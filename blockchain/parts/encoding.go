@@ -0,0 +1,284 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//Canonical encoding of blocks and transactions
+//It is used for transaction IDs, merkle leaves, storage and network, so every implementation must write the same bytes
+//Payloads of network messages use the same fields, see payload.go
+//
+//  uvarint  unsigned LEB128 as encoding/binary.PutUvarint, in the fewest bytes
+//  varint   zigzag signed LEB128 as encoding/binary.PutVarint, in the fewest bytes
+//  bytes    uvarint length followed by data. Empty and nil are the same
+//
+//  Transaction = byte version(1), bytes ID, uvarint len(Vin), Vin..., uvarint len(Vout), Vout...
+//  TxInput     = bytes Txid, varint Vout, bytes Signature, bytes PubKey
+//  TxOutput    = varint Value, bytes PubKeyHash
+//  Block       = byte version(2), BlockHeader.Serialize, bytes Hash, uvarint len(Transactions), bytes Transaction...
+//
+//Transaction ID is SHA-256 of the transaction encoded with empty ID and without signatures
+//Fields are written in the order above, and decoders reject unknown version and trailing bytes
+//Version 1 of block had header fields as varints and bytes instead of fixed header
+const (
+	encodingVersion      = 1
+	blockEncodingVersion = 2
+)
+
+//errBadEncoding means data is truncated or not written by canonical encoder
+var errBadEncoding = errors.New("Data is not in canonical encoding")
+
+//encoder append fields of canonical encoding
+type encoder struct {
+	buff bytes.Buffer
+	num  [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) putUvarint(v uint64) {
+	n := binary.PutUvarint(e.num[:], v)
+	e.buff.Write(e.num[:n])
+}
+
+func (e *encoder) putVarint(v int64) {
+	n := binary.PutVarint(e.num[:], v)
+	e.buff.Write(e.num[:n])
+}
+
+func (e *encoder) putBytes(data []byte) {
+	e.putUvarint(uint64(len(data)))
+	e.buff.Write(data)
+}
+
+//decoder read fields written by encoder
+//The first error is kept and every later read returns zero value
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{r: bytes.NewReader(data)}
+}
+
+//uvarint read uvarint. Value padded with extra bytes is rejected, so a value has only one encoding
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	left := d.r.Len()
+	v, err := binary.ReadUvarint(d.r)
+	var num [binary.MaxVarintLen64]byte
+	if err != nil || left-d.r.Len() != binary.PutUvarint(num[:], v) {
+		d.err = errBadEncoding
+	}
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	left := d.r.Len()
+	v, err := binary.ReadVarint(d.r)
+	var num [binary.MaxVarintLen64]byte
+	if err != nil || left-d.r.Len() != binary.PutVarint(num[:], v) {
+		d.err = errBadEncoding
+	}
+	return v
+}
+
+//count read number of items. Each item takes at least one byte, so larger count is broken data
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.err = errBadEncoding
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n > uint64(d.r.Len()) {
+		d.err = errBadEncoding
+		return nil
+	}
+
+	data := make([]byte, n)
+	d.r.Read(data)
+	return data
+}
+
+func (d *decoder) version(expected byte) {
+	if d.err != nil {
+		return
+	}
+	v, err := d.r.ReadByte()
+	if err != nil {
+		d.err = errBadEncoding
+	} else if v != expected {
+		d.err = fmt.Errorf("Unsupported encoding version %d", v)
+	}
+}
+
+//fixed read n bytes without length
+func (d *decoder) fixed(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > d.r.Len() {
+		d.err = errBadEncoding
+		return nil
+	}
+
+	data := make([]byte, n)
+	d.r.Read(data)
+	return data
+}
+
+//finish return error of any read or error of bytes left after the last field
+func (d *decoder) finish() error {
+	if d.err == nil && d.r.Len() != 0 {
+		d.err = errBadEncoding
+	}
+	return d.err
+}
+
+func (e *encoder) putOutput(out TxOutput) {
+	e.putVarint(int64(out.Value))
+	e.putBytes(out.PubKeyHash)
+}
+
+func (d *decoder) output() TxOutput {
+	value := d.varint()
+	return TxOutput{Value: int(value), PubKeyHash: d.bytes()}
+}
+
+func encodeTransaction(tx *Transaction) []byte {
+	e := &encoder{}
+
+	e.buff.WriteByte(encodingVersion)
+	e.putBytes(tx.ID)
+	e.putUvarint(uint64(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		e.putBytes(vin.Txid)
+		e.putVarint(int64(vin.Vout))
+		e.putBytes(vin.Signature)
+		e.putBytes(vin.PubKey)
+	}
+	e.putUvarint(uint64(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		e.putOutput(out)
+	}
+
+	return e.buff.Bytes()
+}
+
+func decodeTransaction(data []byte) (Transaction, error) {
+	var tx Transaction
+	d := newDecoder(data)
+
+	d.version(encodingVersion)
+	tx.ID = d.bytes()
+	for i, n := 0, d.count(); i < n; i++ {
+		var vin TxInput
+		vin.Txid = d.bytes()
+		vin.Vout = int(d.varint())
+		vin.Signature = d.bytes()
+		vin.PubKey = d.bytes()
+		tx.Vin = append(tx.Vin, vin)
+	}
+	for i, n := 0, d.count(); i < n; i++ {
+		tx.Vout = append(tx.Vout, d.output())
+	}
+
+	return tx, d.finish()
+}
+
+func encodeBlock(b *Block) []byte {
+	e := &encoder{}
+
+	e.buff.WriteByte(blockEncodingVersion)
+	e.buff.Write(b.BlockHeader.Serialize())
+	e.putBytes(b.Hash)
+	e.putUvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.putBytes(encodeTransaction(tx))
+	}
+
+	return e.buff.Bytes()
+}
+
+func decodeCanonicalBlock(data []byte) (*Block, error) {
+	var block Block
+	d := newDecoder(data)
+
+	d.version(blockEncodingVersion)
+	if header := d.fixed(blockHeaderLen); d.err == nil {
+		block.BlockHeader, d.err = DeserializeBlockHeader(header)
+	}
+	block.Hash = d.bytes()
+	for i, n := 0, d.count(); i < n && d.err == nil; i++ {
+		tx, err := decodeTransaction(d.bytes())
+		if err != nil && d.err == nil {
+			d.err = err
+		}
+		block.Transactions = append(block.Transactions, &tx)
+	}
+
+	return &block, d.finish()
+}
+
+//encodeOutputs write uvarint count and index and output of every entry
+func encodeOutputs(outs TxOutputs) []byte {
+	e := &encoder{}
+
+	e.putUvarint(uint64(len(outs.Outputs)))
+	for i, out := range outs.Outputs {
+		e.putUvarint(uint64(outs.Index(i)))
+		e.putOutput(out)
+	}
+
+	return e.buff.Bytes()
+}
+
+func decodeCanonicalOutputs(data []byte) (TxOutputs, error) {
+	var outs TxOutputs
+	d := newDecoder(data)
+
+	for i, n := 0, d.count(); i < n; i++ {
+		outs.Indexes = append(outs.Indexes, int(d.uvarint()))
+		outs.Outputs = append(outs.Outputs, d.output())
+	}
+
+	return outs, d.finish()
+}
+
+//encodeUndo write uvarint count and spent outputs
+func encodeUndo(undo BlockUndo) []byte {
+	e := &encoder{}
+
+	e.putUvarint(uint64(len(undo.SpentOutputs)))
+	for _, out := range undo.SpentOutputs {
+		e.putOutput(out)
+	}
+
+	return e.buff.Bytes()
+}
+
+func decodeUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+	d := newDecoder(data)
+
+	for i, n := 0, d.count(); i < n; i++ {
+		undo.SpentOutputs = append(undo.SpentOutputs, d.output())
+	}
+
+	return undo, d.finish()
+}
@@ -0,0 +1,177 @@
+package parts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func testTransaction() *Transaction {
+	tx := &Transaction{
+		Vin: []TxInput{
+			{Txid: bytes.Repeat([]byte{1}, 32), Vout: 0, PubKey: []byte{4, 5}},
+			{Txid: bytes.Repeat([]byte{6}, 32), Vout: 300, PubKey: []byte{8}},
+		},
+		Vout: []TxOutput{
+			{Value: 10, PubKeyHash: []byte{9}},
+			{Value: maxMoney, PubKeyHash: []byte{10, 11}},
+		},
+	}
+	//ID does not cover signatures
+	tx.ID = tx.Hash()
+	tx.Vin[0].Signature = []byte{2, 3}
+	tx.Vin[1].Signature = []byte{7}
+
+	return tx
+}
+
+func testBlock() *Block {
+	return &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: bytes.Repeat([]byte{1}, 32),
+			MerkleRoot:    bytes.Repeat([]byte{2}, 32),
+			TimeStamp:     1600000000,
+			Bits:          0x1f00ffff,
+			Nonce:         42,
+			Height:        7,
+		},
+		Hash:         bytes.Repeat([]byte{3}, 32),
+		Transactions: []*Transaction{testTransaction(), testTransaction()},
+	}
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	tx := testTransaction()
+
+	decoded, err := decodeTransaction(encodeTransaction(tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, tx) {
+		t.Fatalf("Decoded %+v, expected %+v", decoded, tx)
+	}
+	if !decoded.HasValidID() {
+		t.Fatal("ID of decoded transaction does not match")
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	b := testBlock()
+
+	decoded, err := decodeCanonicalBlock(encodeBlock(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, b) {
+		t.Fatalf("Decoded %+v, expected %+v", decoded, b)
+	}
+}
+
+func TestOutputsRoundTrip(t *testing.T) {
+	outs := TxOutputs{}
+	outs.Add(0, TxOutput{Value: 1, PubKeyHash: []byte{1}})
+	outs.Add(5, TxOutput{Value: 2, PubKeyHash: []byte{2}})
+
+	decoded, err := decodeCanonicalOutputs(encodeOutputs(outs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, outs) {
+		t.Fatalf("Decoded %+v, expected %+v", decoded, outs)
+	}
+}
+
+func TestUndoRoundTrip(t *testing.T) {
+	undo := BlockUndo{SpentOutputs: testTransaction().Vout}
+
+	decoded, err := decodeUndo(encodeUndo(undo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, undo) {
+		t.Fatalf("Decoded %+v, expected %+v", decoded, undo)
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	payloads := []payload{
+		&verzion{nodeVersion, 12, "localhost:3000", true},
+		&getheaders{"localhost:3001", 3, [][]byte{{1}, {2}}, []byte{3}},
+		&proofs{"localhost:3002", []txProof{{[]byte{1}, []byte{2}, MerkleProof{3, [][]byte{{4}}}, []int{0, 2}}}},
+		&mempoolInfo{"localhost:3003", []MempoolEntryInfo{{[]byte{1}, 2, 3, 4, [][]byte{{5}}}}},
+	}
+
+	for _, p := range payloads {
+		decoded := reflect.New(reflect.TypeOf(p).Elem()).Interface().(payload)
+		err := decodePayload(encodePayload(p), decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, p) {
+			t.Fatalf("Decoded %+v, expected %+v", decoded, p)
+		}
+	}
+}
+
+//Every prefix of an encoding is shorter than some field, and extra bytes are left after the last field
+func TestRejectTruncatedAndTrailingData(t *testing.T) {
+	decoders := map[string]func([]byte) error{
+		"transaction": func(data []byte) error {
+			_, err := decodeTransaction(data)
+			return err
+		},
+		"block": func(data []byte) error {
+			_, err := decodeCanonicalBlock(data)
+			return err
+		},
+		"outputs": func(data []byte) error {
+			_, err := decodeCanonicalOutputs(data)
+			return err
+		},
+		"undo": func(data []byte) error {
+			_, err := decodeUndo(data)
+			return err
+		},
+	}
+	outs := TxOutputs{}
+	outs.Add(1, TxOutput{Value: 1, PubKeyHash: []byte{1}})
+	encodings := map[string][]byte{
+		"transaction": encodeTransaction(testTransaction()),
+		"block":       encodeBlock(testBlock()),
+		"outputs":     encodeOutputs(outs),
+		"undo":        encodeUndo(BlockUndo{SpentOutputs: testTransaction().Vout}),
+	}
+
+	for name, data := range encodings {
+		decode := decoders[name]
+		for n := 0; n < len(data); n++ {
+			if decode(data[:n]) == nil {
+				t.Errorf("%s truncated to %d of %d bytes is accepted", name, n, len(data))
+			}
+		}
+		if decode(append(append([]byte{}, data...), 0)) == nil {
+			t.Errorf("%s with trailing byte is accepted", name)
+		}
+	}
+}
+
+func TestRejectNonMinimalVarint(t *testing.T) {
+	//1 written in two bytes
+	d := newDecoder([]byte{0x81, 0x00})
+	d.uvarint()
+	if d.finish() == nil {
+		t.Fatal("Padded uvarint is accepted")
+	}
+
+	d = newDecoder([]byte{0x82, 0x00})
+	d.varint()
+	if d.finish() == nil {
+		t.Fatal("Padded varint is accepted")
+	}
+
+	d = newDecoder([]byte{0x00})
+	if d.uvarint() != 0 || d.finish() != nil {
+		t.Fatal("Zero is rejected")
+	}
+}
@@ -0,0 +1,73 @@
+package parts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+//blockVersion is version of blocks mined by this node
+//Miners can signal readiness for new rules with higher version
+const (
+	blockVersion   = 1
+	blockHashLen   = sha256.Size
+	blockHeaderLen = 4 + blockHashLen + blockHashLen + 8 + 4 + 8 + 8
+)
+
+//BlockHeader is the part of block covered by block hash
+//Transactions are committed to by MerkleRoot
+type BlockHeader struct {
+	Version       uint32
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	TimeStamp     int64
+	Bits          uint32
+	Nonce         int
+	Height        int
+}
+
+//Serialize write header in fixed big endian layout of blockHeaderLen bytes
+//version(4), prev block hash(32), merkle root(32), timestamp(8), bits(4), nonce(8), height(8)
+//Genesis block has 32 zero bytes as prev block hash
+func (h *BlockHeader) Serialize() []byte {
+	data := make([]byte, blockHeaderLen)
+
+	binary.BigEndian.PutUint32(data[0:], h.Version)
+	copy(data[4:36], h.PrevBlockHash)
+	copy(data[36:68], h.MerkleRoot)
+	binary.BigEndian.PutUint64(data[68:], uint64(h.TimeStamp))
+	binary.BigEndian.PutUint32(data[76:], h.Bits)
+	binary.BigEndian.PutUint64(data[80:], uint64(h.Nonce))
+	binary.BigEndian.PutUint64(data[88:], uint64(h.Height))
+
+	return data
+}
+
+//DeserializeBlockHeader read header written by Serialize
+func DeserializeBlockHeader(data []byte) (BlockHeader, error) {
+	var h BlockHeader
+	if len(data) != blockHeaderLen {
+		return h, errors.New("Block header has wrong length")
+	}
+
+	h.Version = binary.BigEndian.Uint32(data[0:])
+	if prev := data[4:36]; !bytes.Equal(prev, make([]byte, blockHashLen)) {
+		h.PrevBlockHash = append([]byte{}, prev...)
+	}
+	h.MerkleRoot = append([]byte{}, data[36:68]...)
+	h.TimeStamp = int64(binary.BigEndian.Uint64(data[68:]))
+	h.Bits = binary.BigEndian.Uint32(data[76:])
+	h.Nonce = int(binary.BigEndian.Uint64(data[80:]))
+	h.Height = int(binary.BigEndian.Uint64(data[88:]))
+
+	return h, nil
+}
+
+//BlockHash return double SHA-256 of serialized header
+func (h *BlockHeader) BlockHash() []byte {
+	first := sha256.Sum256(h.Serialize())
+	second := sha256.Sum256(first[:])
+
+	return second[:]
+}
@@ -0,0 +1,154 @@
+package parts
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+//heightBucket map height of main chain block to its hash
+//Height of tip is kept in blocks bucket with key "h" next to "l"
+const heightBucket = "heights"
+
+//heightKey is big endian so cursor walks heights in order
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+//initHeightIndex index every main chain block
+//It does nothing when height of tip is already stored
+func initHeightIndex(tx StorageTx, tip []byte) error {
+	hb, err := tx.CreateBucketIfNotExists([]byte(heightBucket))
+	if err != nil {
+		return err
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	if b.Get([]byte("h")) != nil {
+		return nil
+	}
+
+	tipHeight := -1
+	for hash := tip; len(hash) > 0; {
+		block := DeserializeBlock(b.Get(hash))
+		if tipHeight < 0 {
+			tipHeight = block.Height
+		}
+
+		err = hb.Put(heightKey(block.Height), block.Hash)
+		if err != nil {
+			return err
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return b.Put([]byte("h"), heightKey(tipHeight))
+}
+
+//updateHeightIndex move height index to the new main chain
+//It must be called in db transaction of AddBlock
+func updateHeightIndex(tx StorageTx, update *ChainUpdate) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	hb := tx.Bucket([]byte(heightBucket))
+
+	for _, block := range update.Disconnected {
+		err := hb.Delete(heightKey(block.Height))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, block := range update.Connected {
+		err := hb.Put(heightKey(block.Height), block.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	tip := update.Connected[len(update.Connected)-1]
+	return b.Put([]byte("h"), heightKey(tip.Height))
+}
+
+//GetBlockHash return hash of main chain block at height
+func (bc *BlockChain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		hb := tx.Bucket([]byte(heightBucket))
+		hashData := hb.Get(heightKey(height))
+		if height < 0 || hashData == nil {
+			return errors.New("Block is not found at the height")
+		}
+
+		hash = append([]byte{}, hashData...)
+		return nil
+	})
+
+	return hash, err
+}
+
+//GetBlockByHeight return main chain block at height
+func (bc *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := bc.GetBlockHash(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(hash)
+}
+
+//GetBlockHashRange return hashes of main chain blocks from height from to height to, both included
+//The range is cut at the tip
+func (bc *BlockChain) GetBlockHashRange(from, to int) [][]byte {
+	var hashes [][]byte
+	if from < 0 {
+		from = 0
+	}
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		c := tx.Bucket([]byte(heightBucket)).Cursor()
+
+		for k, v := c.Seek(heightKey(from)); k != nil; k, v = c.Next() {
+			if binary.BigEndian.Uint64(k) > uint64(to) {
+				break
+			}
+			hashes = append(hashes, append([]byte{}, v...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return hashes
+}
+
+//GetBlocksByHeight return main chain blocks from height from to height to, both included
+func (bc *BlockChain) GetBlocksByHeight(from, to int) []*Block {
+	var blocks []*Block
+	if from < 0 {
+		from = 0
+	}
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		c := tx.Bucket([]byte(heightBucket)).Cursor()
+
+		for k, v := c.Seek(heightKey(from)); k != nil; k, v = c.Next() {
+			if binary.BigEndian.Uint64(k) > uint64(to) {
+				break
+			}
+			blocks = append(blocks, DeserializeBlock(b.Get(v)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return blocks
+}
@@ -0,0 +1,162 @@
+package parts
+
+import (
+	"bytes"
+	"fmt"
+)
+
+//metaBucket keep options of chain database
+//Key of an enabled index is its name, and value is hash of the last block indexed
+const metaBucket = "meta"
+
+//Indexer keep optional index of main chain
+//Init makes the index empty, dropping what was indexed before
+//ConnectBlock and DisconnectBlock are called in db transaction of AddBlock
+type Indexer interface {
+	Name() string
+	Init(tx StorageTx) error
+	ConnectBlock(tx StorageTx, block *Block) error
+	DisconnectBlock(tx StorageTx, block *Block) error
+}
+
+//indexers make index by name, so indexes enabled once are loaded again
+var indexers = map[string]func() Indexer{}
+
+//EnableIndex start keeping index
+//Blocks of main chain which are not indexed yet are indexed before it returns
+func (bc *BlockChain) EnableIndex(name string) error {
+	newIndexer, ok := indexers[name]
+	if !ok {
+		return fmt.Errorf("Unknown index %s", name)
+	}
+	for _, idx := range bc.indexers {
+		if idx.Name() == name {
+			return nil
+		}
+	}
+
+	idx := newIndexer()
+	err := bc.Db.Update(func(tx StorageTx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+
+		return catchUpIndex(tx, idx, meta.Get([]byte(name)))
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.indexers = append(bc.indexers, idx)
+	return nil
+}
+
+//loadIndexes enable indexes recorded in meta bucket
+func (bc *BlockChain) loadIndexes() error {
+	var names []string
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil {
+			return nil
+		}
+
+		for name := range indexers {
+			if meta.Get([]byte(name)) != nil {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = bc.EnableIndex(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//catchUpIndex index main chain blocks after indexedHash
+//Index is built from genesis when indexedHash is nil or not in main chain any more
+func catchUpIndex(tx StorageTx, idx Indexer, indexedHash []byte) error {
+	b := tx.Bucket([]byte(blocksBucket))
+	hb := tx.Bucket([]byte(heightBucket))
+
+	from := 0
+	if indexedHash != nil {
+		indexed := DeserializeBlock(b.Get(indexedHash))
+		if bytes.Equal(hb.Get(heightKey(indexed.Height)), indexed.Hash) {
+			from = indexed.Height + 1
+		}
+	}
+
+	//The index is new or was left on a disconnected branch
+	if from == 0 {
+		err := idx.Init(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := hb.Cursor()
+	for k, v := c.Seek(heightKey(from)); k != nil; k, v = c.Next() {
+		block := DeserializeBlock(b.Get(v))
+		if block.IsPruned() {
+			return fmt.Errorf("Cannot build %s because block at height %d is pruned", idx.Name(), block.Height)
+		}
+
+		err := idx.ConnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket([]byte(metaBucket)).Put([]byte(idx.Name()), b.Get([]byte("l")))
+}
+
+//disconnectIndexes remove blocks from enabled indexes
+//It must be called in db transaction of AddBlock before UTXO set is disconnected,
+//because indexes may read undo data of the blocks
+func (bc *BlockChain) disconnectIndexes(tx StorageTx, blocks []*Block) error {
+	for _, idx := range bc.indexers {
+		for _, block := range blocks {
+			err := idx.DisconnectBlock(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//connectIndexes add blocks to enabled indexes and record the last block indexed
+//It must be called in db transaction of AddBlock after UTXO set is connected
+func (bc *BlockChain) connectIndexes(tx StorageTx, blocks []*Block) error {
+	if len(bc.indexers) == 0 {
+		return nil
+	}
+
+	meta := tx.Bucket([]byte(metaBucket))
+	tip := blocks[len(blocks)-1]
+
+	for _, idx := range bc.indexers {
+		for _, block := range blocks {
+			err := idx.ConnectBlock(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := meta.Put([]byte(idx.Name()), tip.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,15 +1,11 @@
 package parts
 
-import (
-	"log"
-
-	"github.com/boltdb/bolt"
-)
+import "log"
 
 //BlockChainIterator define struct for blockchain iteration
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Db          *bolt.DB
+	Db          Storage
 }
 
 //Iterator iterate blockchain
@@ -27,7 +23,7 @@ func (bc *BlockChain) Iterator() *BlockChainIterator {
 func (i *BlockChainIterator) Next() *Block {
 	var block *Block
 
-	err := i.Db.View(func(tx *bolt.Tx) error {
+	err := i.Db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		//Get serialized block
 		encodedBlock := b.Get(i.CurrentHash)
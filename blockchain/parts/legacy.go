@@ -0,0 +1,182 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+)
+
+//Keys of meta bucket for encoding
+//encodingKey is version of canonical encoding of the db. Db without it was written with gob
+//legacyHeightKey is the highest main chain block whose transaction IDs were made with gob
+const (
+	encodingKey     = "encoding"
+	legacyHeightKey = "legacyheight"
+)
+
+//legacyBlock is Block before BlockHeader was split out
+//Its hash was SHA-256 of hex strings of header fields
+type legacyBlock struct {
+	TimeStamp     int64
+	Transactions  []*Transaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+	Bits          uint32
+	MerkleRoot    []byte
+}
+
+//decodeLegacyBlock read block written with gob. Version of the block is 0
+//Blocks before compact target have no Bits and were mined with fixed target of initialTargetBits
+func decodeLegacyBlock(data []byte) (*Block, error) {
+	var old legacyBlock
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&old)
+	if old.Bits == 0 {
+		old.Bits = initialBits
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{
+			PrevBlockHash: old.PrevBlockHash,
+			MerkleRoot:    old.MerkleRoot,
+			TimeStamp:     old.TimeStamp,
+			Bits:          old.Bits,
+			Nonce:         old.Nonce,
+			Height:        old.Height,
+		},
+		Hash:         old.Hash,
+		Transactions: old.Transactions,
+	}
+	return block, err
+}
+
+func decodeLegacyOutputs(data []byte) (TxOutputs, error) {
+	var outs TxOutputs
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&outs)
+	return outs, err
+}
+
+func decodeLegacyUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo)
+	return undo, err
+}
+
+//setEncoding mark db as written in canonical encoding
+func setEncoding(tx StorageTx) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return err
+	}
+
+	return meta.Put([]byte(encodingKey), []byte{encodingVersion})
+}
+
+//migrateEncoding rewrite blocks, chainstate and undo data of db written with gob in canonical encoding
+//Hashes of old blocks were made from hex strings, and their IDs, signatures and merkle roots from gob.
+//They cannot change without mining the blocks again, so they are kept as they are,
+//and main chain blocks up to the current tip are trusted like checkpoints:
+//they are not checked again, they are not exported and they cannot be disconnected
+func migrateEncoding(tx StorageTx) error {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta != nil && meta.Get([]byte(encodingKey)) != nil {
+		return nil
+	}
+
+	type entry struct {
+		key   []byte
+		value []byte
+	}
+	migrate := func(name string, convert func([]byte) ([]byte, error)) (int, error) {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return 0, nil
+		}
+
+		//Values are put after the cursor is done because changing bucket may invalidate it
+		var entries []entry
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if name == blocksBucket && (string(k) == "l" || string(k) == "h") {
+				continue
+			}
+
+			value, err := convert(v)
+			if err != nil {
+				return 0, fmt.Errorf("Cannot migrate %x of %s: %s", k, name, err)
+			}
+			entries = append(entries, entry{append([]byte{}, k...), value})
+		}
+
+		for _, e := range entries {
+			err := b.Put(e.key, e.value)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(entries), nil
+	}
+
+	fmt.Println("Migrating storage from gob to canonical encoding...")
+	blocks, err := migrate(blocksBucket, func(v []byte) ([]byte, error) {
+		block, err := decodeLegacyBlock(v)
+		return encodeBlock(block), err
+	})
+	if err != nil {
+		return err
+	}
+	entries, err := migrate(utxoBucket, func(v []byte) ([]byte, error) {
+		outs, err := decodeLegacyOutputs(v)
+		return encodeOutputs(outs), err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = migrate(undoBucket, func(v []byte) ([]byte, error) {
+		undo, err := decodeLegacyUndo(v)
+		return encodeUndo(undo), err
+	})
+	if err != nil {
+		return err
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	tip, err := decodeCanonicalBlock(b.Get(b.Get([]byte("l"))))
+	if err != nil {
+		return err
+	}
+
+	err = setEncoding(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket([]byte(metaBucket)).Put([]byte(legacyHeightKey), heightKey(tip.Height))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated %d blocks and %d UTXO entries. Blocks up to height %d keep IDs made with gob\n", blocks, entries, tip.Height)
+	return nil
+}
+
+func readLegacyHeight(tx StorageTx) int {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return -1
+	}
+
+	legacyHeight := meta.Get([]byte(legacyHeightKey))
+	if legacyHeight == nil {
+		return -1
+	}
+	return int(binary.BigEndian.Uint64(legacyHeight))
+}
+
+//LegacyHeight return the highest block migrated from gob encoding. It is -1 when db was created in canonical encoding
+func (bc *BlockChain) LegacyHeight() int {
+	return bc.legacyHeight
+}
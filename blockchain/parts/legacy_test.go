@@ -0,0 +1,151 @@
+package parts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"math/big"
+	"testing"
+	"time"
+)
+
+//baselineBlock is Block as it was written with gob before BlockHeader, Bits and merkle root
+type baselineBlock struct {
+	TimeStamp     int64
+	Transactions  []*Transaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+}
+
+//baselineOutputs is TxOutputs before Indexes
+type baselineOutputs struct {
+	Outputs []TxOutput
+}
+
+func gobEncode(t *testing.T, v interface{}) []byte {
+	var buff bytes.Buffer
+
+	err := gob.NewEncoder(&buff).Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buff.Bytes()
+}
+
+//baselineGenesis mine genesis block to address the way it was done before canonical encoding
+//ID is hash of gob, and block hash is hash of hex strings of fields with fixed target
+func baselineGenesis(t *testing.T, address string) *baselineBlock {
+	coinbase := NewCoinbaseTx(address, "", 0, 0)
+	coinbase.ID = []byte{}
+	id := sha256.Sum256(gobEncode(t, coinbase))
+	coinbase.ID = id[:]
+
+	block := &baselineBlock{
+		TimeStamp:    time.Now().Unix(),
+		Transactions: []*Transaction{coinbase},
+	}
+	txHash := sha256.Sum256(coinbase.ID)
+	target := new(big.Int).Lsh(big.NewInt(1), 256-initialTargetBits)
+	for {
+		data := bytes.Join([][]byte{
+			block.PrevBlockHash,
+			txHash[:],
+			IntToHex(block.TimeStamp),
+			IntToHex(initialTargetBits),
+			IntToHex(int64(block.Nonce)),
+		}, []byte{})
+		hash := sha256.Sum256(data)
+		if new(big.Int).SetBytes(hash[:]).Cmp(target) < 0 {
+			block.Hash = hash[:]
+			return block
+		}
+		block.Nonce++
+	}
+}
+
+//baselineStorage return storage of chain of genesis block written with gob
+func baselineStorage(t *testing.T, genesis *baselineBlock) Storage {
+	s := NewMemoryStorage()
+
+	err := s.Update(func(tx StorageTx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		err = b.Put(genesis.Hash, gobEncode(t, genesis))
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte("l"), genesis.Hash)
+		if err != nil {
+			return err
+		}
+
+		u, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+		coinbase := genesis.Transactions[0]
+		return u.Put(coinbase.ID, gobEncode(t, baselineOutputs{coinbase.Vout}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+//Blocks mined before compact target have no Bits, so migrated chain must use target they were mined with
+func TestMineOnMigratedChain(t *testing.T) {
+	address := string(NewWallet().GetAddress())
+	bc := NewBlockChainWithStorage(baselineStorage(t, baselineGenesis(t, address)))
+
+	block, err := bc.MineBlock([]*Transaction{NewCoinbaseTx(address, "", 1, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Bits != initialBits || bc.GetBestHeight() != 1 {
+		t.Fatalf("Block has bits %x at height %d, expected %x at height 1", block.Bits, bc.GetBestHeight(), initialBits)
+	}
+}
+
+//Genesis block and chainstate written with gob are rewritten in canonical encoding with hash and IDs they were made with
+func TestMigrateBaselineChain(t *testing.T) {
+	genesis := baselineGenesis(t, string(NewWallet().GetAddress()))
+	coinbase := genesis.Transactions[0]
+	storage := baselineStorage(t, genesis)
+
+	bc := NewBlockChainWithStorage(storage)
+	if bc.LegacyHeight() != 0 || !bytes.Equal(bc.Tip, genesis.Hash) {
+		t.Fatalf("Legacy height is %d and tip %x, expected 0 and %x", bc.LegacyHeight(), bc.Tip, genesis.Hash)
+	}
+
+	err := storage.View(func(tx StorageTx) error {
+		block, err := decodeCanonicalBlock(tx.Bucket([]byte(blocksBucket)).Get(genesis.Hash))
+		if err != nil {
+			return err
+		}
+		if block.Bits != initialBits || block.Version != 0 || !bytes.Equal(block.Transactions[0].ID, coinbase.ID) {
+			t.Fatalf("Migrated block has bits %x, version %d and coinbase %x", block.Bits, block.Version, block.Transactions[0].ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := UTXOSet{bc}.FindOutput(coinbase.ID, 0)
+	if !ok || out.Value != coinbase.Vout[0].Value {
+		t.Fatal("Output of migrated coinbase is lost")
+	}
+	if problems := bc.VerifyChain(3); len(problems) != 0 {
+		t.Fatalf("Migrated chain has problems: %v", problems)
+	}
+
+	//Migrated storage is not migrated again
+	if reopened := NewBlockChainWithStorage(storage); reopened.LegacyHeight() != 0 {
+		t.Fatalf("Legacy height is %d after reopen", reopened.LegacyHeight())
+	}
+}
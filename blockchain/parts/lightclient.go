@@ -0,0 +1,313 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const (
+	headersFile = "headers.dat"
+	//maxHeaderRewind is the deepest fork light client follows. Deeper fork needs more work than it can check
+	maxHeaderRewind = 100
+)
+
+//errHeaderFork means full node sent header which does not follow our last header
+var errHeaderFork = errors.New("Header does not connect to the last header")
+
+//LightClient keep only block headers and verify transactions with merkle proofs
+//It asks a full node for headers and proofs instead of storing blockchain.db
+type LightClient struct {
+	Headers []*Block
+	Wallets *Wallets
+	hashes  map[string]*Block
+	dataDir DataDir
+	node    *Node
+}
+
+//NewLightClient load headers saved by previous run and wallets of node
+func NewLightClient(nodeID string, dir DataDir) *LightClient {
+	wallets, _ := NewWallets(dir)
+	lc := LightClient{
+		Headers: []*Block{},
+		Wallets: wallets,
+		hashes:  make(map[string]*Block),
+		dataDir: dir,
+		node:    NewNode(nodeID, "", dir),
+	}
+
+	if _, err := os.Stat(dir.HeadersFile()); os.IsNotExist(err) {
+		return &lc
+	}
+
+	fileContent, err := ioutil.ReadFile(dir.HeadersFile())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	//Headers are length prefixed blocks in canonical encoding
+	var saved []*Block
+	r := bytes.NewReader(fileContent)
+	for {
+		data, err := readChunk(r, maxBootstrapBlockLen)
+		if err == io.EOF {
+			break
+		}
+		var header *Block
+		if err == nil {
+			header, err = decodeBlock(data)
+		}
+		if err != nil {
+			//File written in gob by older version is dropped and synced again
+			fmt.Printf("Cannot read headers file: %s. Headers are synced again\n", err)
+			saved = nil
+			break
+		}
+		saved = append(saved, header)
+	}
+
+	for _, header := range saved {
+		lc.Headers = append(lc.Headers, header)
+		lc.hashes[hex.EncodeToString(header.Hash)] = header
+	}
+
+	return &lc
+}
+
+//lookup find header by hash
+func (lc *LightClient) lookup(hash []byte) *Block {
+	return lc.hashes[hex.EncodeToString(hash)]
+}
+
+//addHeader check header against last header and append it
+func (lc *LightClient) addHeader(header *Block) error {
+	err := CheckHeaderSanity(header)
+	if err != nil {
+		return err
+	}
+
+	if len(lc.Headers) == 0 {
+		//First header is trusted as genesis of the full node
+		if header.Height != 0 || len(header.PrevBlockHash) != 0 {
+			return errors.New("First header is not genesis")
+		}
+	} else {
+		parent := lc.Headers[len(lc.Headers)-1]
+		if bytes.Compare(header.PrevBlockHash, parent.Hash) != 0 {
+			return errHeaderFork
+		}
+
+		err = checkBlockContext(lc.lookup, header, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	lc.Headers = append(lc.Headers, header)
+	lc.hashes[hex.EncodeToString(header.Hash)] = header
+
+	return nil
+}
+
+//rewind drop last header
+func (lc *LightClient) rewind() {
+	last := lc.Headers[len(lc.Headers)-1]
+	delete(lc.hashes, hex.EncodeToString(last.Hash))
+	lc.Headers = lc.Headers[:len(lc.Headers)-1]
+}
+
+//rewindTo drop headers after hash, where chain of full node forked from ours
+//Fork deeper than maxHeaderRewind or from unknown hash is refused, so a full node cannot make light client
+//drop its headers for a chain which is not checked to have more work
+func (lc *LightClient) rewindTo(hash []byte) error {
+	if len(lc.Headers) == 0 {
+		return nil
+	}
+
+	fork := lc.lookup(hash)
+	if fork == nil {
+		return errHeaderFork
+	}
+	if depth := len(lc.Headers) - 1 - fork.Height; depth > maxHeaderRewind {
+		return fmt.Errorf("Full node forks %d headers back, more than %d", depth, maxHeaderRewind)
+	}
+
+	for !bytes.Equal(lc.Headers[len(lc.Headers)-1].Hash, hash) {
+		lc.rewind()
+	}
+	return nil
+}
+
+//locator return locator of synced headers. Height of header is its index
+func (lc *LightClient) locator() [][]byte {
+	if len(lc.Headers) == 0 {
+		return nil
+	}
+
+	return blockLocator(len(lc.Headers)-1, func(height int) []byte {
+		return lc.Headers[height].Hash
+	})
+}
+
+//Sync download new headers from full node and verify proof of work chain
+func (lc *LightClient) Sync() error {
+	for {
+		payload := encodePayload(&getheaders{AddrFrom: lc.node.Address, Locator: lc.locator()})
+
+		data, err := lc.node.requestData(lc.node.centralNode(), "getheaders", payload, "headers")
+		if err != nil {
+			return err
+		}
+
+		var reply headers
+		err = decodePayload(data, &reply)
+		if err != nil {
+			return err
+		}
+
+		for i, headerData := range reply.Headers {
+			header, err := decodeBlock(headerData)
+			if err != nil {
+				return fmt.Errorf("Header %d from full node: %v", i, err)
+			}
+
+			//Headers follow the last locator hash in main chain of full node
+			if i == 0 {
+				err = lc.rewindTo(header.PrevBlockHash)
+				if err != nil {
+					return err
+				}
+			}
+			err = lc.addHeader(header)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(reply.Headers) < maxHeadersPerMsg {
+			break
+		}
+	}
+
+	fmt.Printf("Synced %d headers\n", len(lc.Headers))
+	lc.saveHeaders()
+
+	return nil
+}
+
+//saveHeaders save headers to .dat file
+func (lc *LightClient) saveHeaders() {
+	var content bytes.Buffer
+
+	for _, header := range lc.Headers {
+		err := writeChunk(&content, header.Serialize())
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	err := ioutil.WriteFile(lc.dataDir.HeadersFile(), content.Bytes(), 0644)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//FindUTXO ask full node for unspent outputs of pubKeyHash
+//Only outputs whose transaction is proven to be in a synced header are returned
+func (lc *LightClient) FindUTXO(pubKeyHash []byte) (map[string]Transaction, map[string][]int, error) {
+	prevTxs := make(map[string]Transaction)
+	unspentOutputs := make(map[string][]int)
+
+	payload := encodePayload(&getproofs{lc.node.Address, [][]byte{pubKeyHash}})
+
+	data, err := lc.node.requestData(lc.node.centralNode(), "getproofs", payload, "proofs")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reply proofs
+	err = decodePayload(data, &reply)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, item := range reply.Proofs {
+		tx := DeserializeTransaction(item.Transaction)
+		header := lc.lookup(item.BlockHash)
+
+		if header == nil || !tx.HasValidID() || !VerifyTransactionProof(header.MerkleRoot, &tx, &item.Proof) {
+			fmt.Printf("Proof of transaction %x is invalid\n", tx.ID)
+			continue
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		for _, outIdx := range item.Outputs {
+			if outIdx < 0 || outIdx >= len(tx.Vout) || !tx.Vout[outIdx].IsLockedWithKey(pubKeyHash) {
+				continue
+			}
+			unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+		}
+		prevTxs[txID] = tx
+	}
+
+	return prevTxs, unspentOutputs, nil
+}
+
+//GetBalance return sum of proven unspent outputs of address
+func (lc *LightClient) GetBalance(address string) (int, error) {
+	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+
+	prevTxs, unspentOutputs, err := lc.FindUTXO(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for txID, outs := range unspentOutputs {
+		for _, outIdx := range outs {
+			balance += prevTxs[txID].Vout[outIdx].Value
+		}
+	}
+
+	return balance, nil
+}
+
+//Send make transaction from proven outputs of wallet and send it to full node
+func (lc *LightClient) Send(from, to string, amount, fee int) (*Transaction, error) {
+	if lc.Wallets.Wallets[from] == nil {
+		return nil, errors.New("Sender address is not in the wallet")
+	}
+	wallet := lc.Wallets.GetWallet(from)
+
+	prevTxs, unspentOutputs, err := lc.FindUTXO(HashPubKey(wallet.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	acc := 0
+	validOutputs := make(map[string][]int)
+	for txID, outs := range unspentOutputs {
+		for _, outIdx := range outs {
+			if acc >= amount+fee {
+				break
+			}
+			acc += prevTxs[txID].Vout[outIdx].Value
+			validOutputs[txID] = append(validOutputs[txID], outIdx)
+		}
+	}
+	if acc < amount+fee {
+		return nil, errors.New("Not enough funds")
+	}
+
+	tx := newTransaction(&wallet, to, amount, fee, acc, validOutputs)
+	tx.Sign(wallet.PrivateKey, prevTxs)
+	lc.node.submitTx(lc.node.centralNode(), tx)
+
+	return tx, nil
+}
@@ -0,0 +1,50 @@
+package parts
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+//testLightClient return light client of count headers whose hash is their height
+//rewindTo only follows hashes and heights, so headers have no proof of work
+func testLightClient(count int) *LightClient {
+	lc := &LightClient{hashes: make(map[string]*Block)}
+
+	for height := 0; height < count; height++ {
+		header := &Block{Hash: []byte{byte(height), byte(height >> 8)}}
+		header.Height = height
+		lc.Headers = append(lc.Headers, header)
+		lc.hashes[hex.EncodeToString(header.Hash)] = header
+	}
+
+	return lc
+}
+
+func TestRewindToFork(t *testing.T) {
+	lc := testLightClient(50)
+
+	err := lc.rewindTo(lc.Headers[30].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lc.Headers) != 31 || lc.lookup(lc.Headers[30].Hash) == nil {
+		t.Fatalf("%d headers are left, expected 31", len(lc.Headers))
+	}
+}
+
+func TestRewindIsBounded(t *testing.T) {
+	lc := testLightClient(maxHeaderRewind + 10)
+
+	err := lc.rewindTo(lc.Headers[5].Hash)
+	if err == nil {
+		t.Fatal("Fork deeper than maxHeaderRewind is followed")
+	}
+	if len(lc.Headers) != maxHeaderRewind+10 {
+		t.Fatal("Headers are dropped for refused fork")
+	}
+
+	err = lc.rewindTo([]byte("unknown"))
+	if err == nil || len(lc.Headers) != maxHeaderRewind+10 {
+		t.Fatal("Fork from unknown hash drops headers")
+	}
+}
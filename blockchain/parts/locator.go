@@ -0,0 +1,102 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+)
+
+//denseLocatorHashes is number of latest hashes in locator before the step starts doubling
+const denseLocatorHashes = 10
+
+//blockLocator return hashes from height tip back to genesis
+//Latest hashes are dense and the step doubles after them, so a locator of any chain is short
+func blockLocator(tip int, hashAt func(height int) []byte) [][]byte {
+	var locator [][]byte
+
+	step := 1
+	for height := tip; height > 0; height -= step {
+		locator = append(locator, hashAt(height))
+		if len(locator) >= denseLocatorHashes {
+			step *= 2
+		}
+	}
+
+	return append(locator, hashAt(0))
+}
+
+//BlockLocator return locator of main chain for getblocks and getheaders
+func (bc *BlockChain) BlockLocator() [][]byte {
+	var locator [][]byte
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		hb := tx.Bucket([]byte(heightBucket))
+
+		tip := int(binary.BigEndian.Uint64(b.Get([]byte("h"))))
+		locator = blockLocator(tip, func(height int) []byte {
+			return append([]byte{}, hb.Get(heightKey(height))...)
+		})
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return locator
+}
+
+//LocateFork return height of the first locator hash which is in main chain
+//It returns -1 when no hash is known, so the requester gets blocks from genesis
+func (bc *BlockChain) LocateFork(locator [][]byte) int {
+	fork := -1
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		hb := tx.Bucket([]byte(heightBucket))
+
+		for _, hash := range locator {
+			block, err := decodeBlock(b.Get(hash))
+			if err != nil {
+				continue
+			}
+			if bytes.Equal(hb.Get(heightKey(block.Height)), hash) {
+				fork = block.Height
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return fork
+}
+
+//LocateHashes return hashes of main chain blocks after fork point of locator in order of height
+//They end at stop hash when it is reached, and at most max hashes are returned
+func (bc *BlockChain) LocateHashes(locator [][]byte, stop []byte, max int) [][]byte {
+	from := bc.LocateFork(locator) + 1
+	hashes := bc.GetBlockHashRange(from, from+max-1)
+
+	for i, hash := range hashes {
+		if bytes.Equal(hash, stop) {
+			return hashes[:i+1]
+		}
+	}
+	return hashes
+}
+
+//LocateHeaders return headers of blocks LocateHashes would return
+func (bc *BlockChain) LocateHeaders(locator [][]byte, stop []byte, max int) []*Block {
+	from := bc.LocateFork(locator) + 1
+	headers := bc.GetHeaders(from, max)
+
+	for i, header := range headers {
+		if bytes.Equal(header.Hash, stop) {
+			return headers[:i+1]
+		}
+	}
+	return headers
+}
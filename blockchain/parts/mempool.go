@@ -0,0 +1,326 @@
+package parts
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxMempoolSize = 1024 * 1024
+
+//Errors of Mempool.Add
+var (
+	ErrMempoolDuplicate = errors.New("Transaction is already in mempool")
+	ErrMempoolConflict  = errors.New("Transaction spends output which is spent by other mempool transaction")
+	ErrMempoolCoinbase  = errors.New("Coinbase transaction cannot be in mempool")
+	ErrMempoolFull      = errors.New("Mempool is full and fee rate of transaction is too low")
+)
+
+//MempoolEntry is a transaction waiting to be mined
+//Parents are mempool transactions whose outputs it spends and Children spend its outputs
+type MempoolEntry struct {
+	Tx       Transaction
+	Fee      int
+	Size     int
+	Time     int64
+	Parents  map[string]bool
+	Children map[string]bool
+}
+
+//feeRateLess report whether e pays lower fee per byte than other
+func (e *MempoolEntry) feeRateLess(other *MempoolEntry) bool {
+	return feeRateLess(e.Fee, e.Size, other.Fee, other.Size)
+}
+
+//Mempool keep valid unconfirmed transactions
+//spent map outpoint to ID of mempool transaction spending it. Every method is safe for concurrent use
+type Mempool struct {
+	mu      sync.RWMutex
+	entries map[string]*MempoolEntry
+	spent   map[string]string
+	size    int
+	maxSize int
+}
+
+//NewMempool make empty mempool which holds transactions up to maxSize bytes
+func NewMempool(maxSize int) *Mempool {
+	return &Mempool{
+		entries: make(map[string]*MempoolEntry),
+		spent:   make(map[string]string),
+		maxSize: maxSize,
+	}
+}
+
+//Add validate tx against UTXO set and mempool and keep it
+//Lowest fee rate transactions are evicted with their descendants when mempool is full
+func (mp *Mempool) Add(tx Transaction, u UTXOSet) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.add(tx, u)
+}
+
+func (mp *Mempool) add(tx Transaction, u UTXOSet) error {
+	txID := hex.EncodeToString(tx.ID)
+	if mp.entries[txID] != nil {
+		return ErrMempoolDuplicate
+	}
+	if tx.IsCoinbase() {
+		return ErrMempoolCoinbase
+	}
+
+	err := CheckTransactionSanity(&tx)
+	if err != nil {
+		return err
+	}
+
+	var spentOutputs []TxOutput
+	parents := make(map[string]bool)
+
+	for _, vin := range tx.Vin {
+		if mp.spent[outpointKey(vin.Txid, vin.Vout)] != "" {
+			return ErrMempoolConflict
+		}
+
+		parentID := hex.EncodeToString(vin.Txid)
+		if parent := mp.entries[parentID]; parent != nil {
+			if vin.Vout < 0 || vin.Vout >= len(parent.Tx.Vout) {
+				return ruleError(ErrMissingInput, "Output %x:%d is not found", vin.Txid, vin.Vout)
+			}
+			spentOutputs = append(spentOutputs, parent.Tx.Vout[vin.Vout])
+			parents[parentID] = true
+			continue
+		}
+
+		out, ok := u.FindOutput(vin.Txid, vin.Vout)
+		if !ok {
+			return ruleError(ErrMissingInput, "Output %x:%d is not found", vin.Txid, vin.Vout)
+		}
+		spentOutputs = append(spentOutputs, out)
+	}
+
+	fee, err := checkTransactionInputs(&tx, spentOutputs)
+	if err != nil {
+		return err
+	}
+
+	entry := &MempoolEntry{
+		Tx:       tx,
+		Fee:      fee,
+		Size:     len(tx.Serialize()),
+		Time:     time.Now().Unix(),
+		Parents:  parents,
+		Children: make(map[string]bool),
+	}
+	mp.entries[txID] = entry
+	mp.size += entry.Size
+
+	for parentID := range parents {
+		mp.entries[parentID].Children[txID] = true
+	}
+	for _, vin := range tx.Vin {
+		mp.spent[outpointKey(vin.Txid, vin.Vout)] = txID
+	}
+
+	//Transactions re-added after reorg may already have children in mempool
+	for outIdx := range tx.Vout {
+		if childID := mp.spent[outpointKey(tx.ID, outIdx)]; childID != "" {
+			entry.Children[childID] = true
+			mp.entries[childID].Parents[txID] = true
+		}
+	}
+
+	mp.evict()
+	if mp.entries[txID] == nil {
+		return ErrMempoolFull
+	}
+
+	return nil
+}
+
+//evict remove lowest fee rate transactions and their descendants until mempool fits in maxSize
+func (mp *Mempool) evict() {
+	for mp.size > mp.maxSize && len(mp.entries) > 0 {
+		var lowestID string
+		var lowest *MempoolEntry
+
+		for txID, entry := range mp.entries {
+			if lowest == nil || entry.feeRateLess(lowest) {
+				lowestID = txID
+				lowest = entry
+			}
+		}
+
+		mp.removeWithDescendants(lowestID)
+	}
+}
+
+//remove delete only the entry and unlink it from its relatives
+func (mp *Mempool) remove(txID string) {
+	entry := mp.entries[txID]
+	if entry == nil {
+		return
+	}
+
+	for parentID := range entry.Parents {
+		if parent := mp.entries[parentID]; parent != nil {
+			delete(parent.Children, txID)
+		}
+	}
+	for childID := range entry.Children {
+		if child := mp.entries[childID]; child != nil {
+			delete(child.Parents, txID)
+		}
+	}
+	for _, vin := range entry.Tx.Vin {
+		delete(mp.spent, outpointKey(vin.Txid, vin.Vout))
+	}
+
+	mp.size -= entry.Size
+	delete(mp.entries, txID)
+}
+
+//removeWithDescendants delete the entry and every transaction spending its outputs
+func (mp *Mempool) removeWithDescendants(txID string) {
+	entry := mp.entries[txID]
+	if entry == nil {
+		return
+	}
+
+	for childID := range entry.Children {
+		mp.removeWithDescendants(childID)
+	}
+	mp.remove(txID)
+}
+
+//Update remove transactions confirmed or conflicted by connected blocks
+//and put transactions of disconnected blocks back
+func (mp *Mempool) Update(update *ChainUpdate, u UTXOSet) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, block := range update.Connected {
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+			if mp.entries[txID] != nil {
+				mp.remove(txID)
+				continue
+			}
+
+			if tx.IsCoinbase() {
+				continue
+			}
+			for _, vin := range tx.Vin {
+				if conflictID := mp.spent[outpointKey(vin.Txid, vin.Vout)]; conflictID != "" {
+					mp.removeWithDescendants(conflictID)
+				}
+			}
+		}
+	}
+
+	//Transactions confirmed again in the new branch fail because their inputs are spent
+	for _, tx := range orphanedTransactions(update.Disconnected, update.Connected) {
+		mp.add(*tx, u)
+	}
+
+	mp.removeUnresolvable(u)
+}
+
+//removeUnresolvable drop transactions whose inputs are neither in UTXO set nor in mempool
+//It happens when parent confirmed in disconnected block could not come back
+func (mp *Mempool) removeUnresolvable(u UTXOSet) {
+	for txID, entry := range mp.entries {
+		if mp.entries[txID] == nil {
+			continue
+		}
+
+		for _, vin := range entry.Tx.Vin {
+			if entry.Parents[hex.EncodeToString(vin.Txid)] {
+				continue
+			}
+			if _, ok := u.FindOutput(vin.Txid, vin.Vout); !ok {
+				mp.removeWithDescendants(txID)
+				break
+			}
+		}
+	}
+}
+
+//Has check transaction is in mempool
+func (mp *Mempool) Has(txID []byte) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.entries[hex.EncodeToString(txID)] != nil
+}
+
+//Get return transaction in mempool
+func (mp *Mempool) Get(txID []byte) (Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entry := mp.entries[hex.EncodeToString(txID)]
+	if entry == nil {
+		return Transaction{}, false
+	}
+	return entry.Tx, true
+}
+
+//Count return number of transactions in mempool
+func (mp *Mempool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.entries)
+}
+
+//Transactions return copy of every transaction in mempool
+func (mp *Mempool) Transactions() []*Transaction {
+	var txs []*Transaction
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, entry := range mp.entries {
+		tx := entry.Tx
+		txs = append(txs, &tx)
+	}
+	return txs
+}
+
+//MempoolEntryInfo describe mempool transaction for CLI
+type MempoolEntryInfo struct {
+	TxID    []byte
+	Fee     int
+	Size    int
+	Time    int64
+	Depends [][]byte
+}
+
+//Info return every entry ordered from the highest fee rate
+func (mp *Mempool) Info() []MempoolEntryInfo {
+	var entries []*MempoolEntry
+	var infos []MempoolEntryInfo
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[j].feeRateLess(entries[i])
+	})
+
+	for _, entry := range entries {
+		info := MempoolEntryInfo{entry.Tx.ID, entry.Fee, entry.Size, entry.Time, [][]byte{}}
+		for parentID := range entry.Parents {
+			parent, _ := hex.DecodeString(parentID)
+			info.Depends = append(info.Depends, parent)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -1,6 +1,7 @@
 package parts
 
 import (
+	"bytes"
 	"crypto/sha256"
 )
 
@@ -37,23 +38,29 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 
 //NewMerkleTree generate merkle tree
 //Good way to implement tree from bottom to top
+//Tree of no data has only root whose hash is all zero, so no leaf can be proved against it
 func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	//padding
-	//Padded data is same date with last node
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+	if len(data) == 0 {
+		return &MerkleTree{&MerkleNode{Data: make([]byte, sha256.Size)}}
 	}
 
+	var nodes []MerkleNode
+
 	for _, datum := range data {
 		//Serialize merkle node
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
 
-	//I think log(data) is enough
-	for i := 0; i < len(data)/2; i++ {
+	//Build levels until only root is left
+	//Even single leaf gets a parent so every tree has at least one level
+	for {
+		//padding
+		//Padded node is same with last node of the level
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		var newLevel []MerkleNode
 
 		for j := 0; j < len(nodes); j += 2 {
@@ -62,9 +69,67 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		}
 
 		nodes = newLevel
+		if len(nodes) == 1 {
+			break
+		}
 	}
 
 	mTree := MerkleTree{&nodes[0]}
 
 	return &mTree
 }
+
+//MerkleProof is hashes of siblings from leaf to root
+//Bits of Index tell whether sibling is on the right(0) or left(1) at each level
+type MerkleProof struct {
+	Index  int
+	Hashes [][]byte
+}
+
+//Proof build inclusion proof of index-th leaf
+func (t *MerkleTree) Proof(index int) *MerkleProof {
+	var siblings [][]byte
+
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	//Walk down from root following bits of index
+	node := t.RootNode
+	for level := depth - 1; level >= 0; level-- {
+		if (index>>uint(level))&1 == 0 {
+			siblings = append([][]byte{node.Right.Data}, siblings...)
+			node = node.Left
+		} else {
+			siblings = append([][]byte{node.Left.Data}, siblings...)
+			node = node.Right
+		}
+	}
+
+	return &MerkleProof{index, siblings}
+}
+
+//Verify check datum is a leaf of merkle tree which has root
+//Index must fit in bits of the levels, or one leaf could be proved at many positions
+func (p *MerkleProof) Verify(root, datum []byte) bool {
+	if p.Index < 0 || p.Index>>uint(len(p.Hashes)) != 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(datum)
+	index := p.Index
+
+	for _, sibling := range p.Hashes {
+		var data []byte
+		if index%2 == 0 {
+			data = append(append(data, hash[:]...), sibling...)
+		} else {
+			data = append(append(data, sibling...), hash[:]...)
+		}
+		hash = sha256.Sum256(data)
+		index /= 2
+	}
+
+	return bytes.Compare(hash[:], root) == 0
+}
@@ -0,0 +1,35 @@
+package parts
+
+import (
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	tree := NewMerkleTree(data)
+	root := tree.RootNode.Data
+
+	for i, datum := range data {
+		if !tree.Proof(i).Verify(root, datum) {
+			t.Fatalf("Proof of leaf %d is rejected", i)
+		}
+	}
+	if tree.Proof(0).Verify(root, data[1]) {
+		t.Fatal("Proof of other leaf is accepted")
+	}
+}
+
+//Bits of Index above the levels of the proof are not used by hashing, so they must be rejected
+func TestMerkleProofWrongIndex(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	tree := NewMerkleTree(data)
+	root := tree.RootNode.Data
+
+	proof := tree.Proof(1)
+	for _, index := range []int{1 + 1<<uint(len(proof.Hashes)), -1, -3} {
+		wrong := &MerkleProof{index, proof.Hashes}
+		if wrong.Verify(root, data[1]) {
+			t.Fatalf("Proof with index %d is accepted", index)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package parts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Message is network magic(4), command(12), payload length(4), checksum(4) and payload
+//Command is ASCII padded with zero bytes, and checksum is the first 4 bytes of double SHA-256 of payload
+const (
+	messageHeaderLen  = 4 + commandLength + 4 + 4
+	maxMessagePayload = 32 * 1024 * 1024
+)
+
+//Errors of reading message
+var (
+	ErrWrongNetwork    = errors.New("Message is from other network")
+	ErrBadChecksum     = errors.New("Checksum of message does not match payload")
+	ErrMessageTooLarge = errors.New("Message payload is too large")
+	ErrBadCommand      = errors.New("Command of message is malformed")
+)
+
+//Network separate chains whose nodes must not talk to each other
+//Magic is the first 4 bytes of every message
+type Network struct {
+	Name  string
+	Magic uint32
+}
+
+//Networks which can be selected
+var (
+	MainNet = Network{"mainnet", 0x70626301}
+	TestNet = Network{"testnet", 0x70626302}
+	RegTest = Network{"regtest", 0x70626303}
+)
+
+var networks = []Network{MainNet, TestNet, RegTest}
+
+//activeNetwork is network of nodes made after SelectNetwork. Each node keeps its own copy
+var activeNetwork = MainNet
+
+//SelectNetwork make nodes made from now on send and accept messages of network name
+func SelectNetwork(name string) error {
+	for _, network := range networks {
+		if network.Name == name {
+			activeNetwork = network
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Unknown network %s", name)
+}
+
+//ActiveNetwork return network selected by SelectNetwork. Default is MainNet
+func ActiveNetwork() Network {
+	return activeNetwork
+}
+
+func messageChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+//writeMessage write payload of command in message envelope of network
+func writeMessage(w io.Writer, network Network, command string, payload []byte) error {
+	if len(command) == 0 || len(command) > commandLength {
+		return ErrBadCommand
+	}
+	if len(payload) > maxMessagePayload {
+		return ErrMessageTooLarge
+	}
+
+	header := make([]byte, messageHeaderLen)
+	binary.BigEndian.PutUint32(header[0:], network.Magic)
+	copy(header[4:4+commandLength], command)
+	binary.BigEndian.PutUint32(header[4+commandLength:], uint32(len(payload)))
+	copy(header[8+commandLength:], messageChecksum(payload))
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+//readMessage read next message of network from r and return its command and payload
+//It returns io.EOF only when r ends between messages
+func readMessage(r io.Reader, network Network) (string, []byte, error) {
+	header := make([]byte, messageHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Truncated message header: %s", err)
+	}
+
+	if binary.BigEndian.Uint32(header[0:]) != network.Magic {
+		return "", nil, ErrWrongNetwork
+	}
+
+	command, err := parseCommand(header[4 : 4+commandLength])
+	if err != nil {
+		return "", nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxMessagePayload {
+		return "", nil, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("Truncated %s message: %s", command, err)
+	}
+	if !bytes.Equal(messageChecksum(payload), header[8+commandLength:]) {
+		return "", nil, ErrBadChecksum
+	}
+
+	return command, payload, nil
+}
+
+//parseCommand accept printable ASCII followed only by zero bytes
+func parseCommand(data []byte) (string, error) {
+	end := bytes.IndexByte(data, 0)
+	if end < 0 {
+		end = len(data)
+	}
+	if end == 0 {
+		return "", ErrBadCommand
+	}
+
+	for _, c := range data[:end] {
+		if c < 0x21 || c > 0x7e {
+			return "", ErrBadCommand
+		}
+	}
+	for _, c := range data[end:] {
+		if c != 0 {
+			return "", ErrBadCommand
+		}
+	}
+
+	return string(data[:end]), nil
+}
@@ -0,0 +1,123 @@
+package parts
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const maxBlockSize = 100 * 1024
+
+//blockCandidate is a pool transaction whose inputs are resolved
+type blockCandidate struct {
+	fee  int
+	size int
+}
+
+//feeRateLess report whether feeA per sizeA bytes is lower than feeB per sizeB bytes
+//Fee per byte is compared without division, and products are big.Int because fee times size overflows int
+func feeRateLess(feeA, sizeA, feeB, sizeB int) bool {
+	a := new(big.Int).Mul(big.NewInt(int64(feeA)), big.NewInt(int64(sizeB)))
+	b := new(big.Int).Mul(big.NewInt(int64(feeB)), big.NewInt(int64(sizeA)))
+
+	return a.Cmp(b) < 0
+}
+
+//SelectTransactions choose valid transactions with the highest fee rate until block reaches maxBlockSize
+//Transaction spending output of other pool transaction is taken only after its parent.
+//It returns selected transactions in spending order and the sum of their fees
+func (u UTXOSet) SelectTransactions(pool []*Transaction) ([]*Transaction, int) {
+	var selected []*Transaction
+	fees := 0
+	size := 0
+	created := make(map[string]TxOutput)
+	spent := make(map[string]bool)
+	checked := make(map[*Transaction]*blockCandidate)
+	remaining := pool
+
+	for {
+		best := -1
+
+		for i, tx := range remaining {
+			candidate, ok := checked[tx]
+			if !ok {
+				spentOutputs, resolved := u.resolveInputs(tx, created, spent)
+				if !resolved {
+					continue
+				}
+
+				fee, err := checkTransactionInputs(tx, spentOutputs)
+				if err != nil {
+					continue
+				}
+				candidate = &blockCandidate{fee, len(tx.Serialize())}
+				checked[tx] = candidate
+			}
+
+			if size+candidate.size > maxBlockSize || !inputsUnspent(tx, spent) {
+				continue
+			}
+
+			if best < 0 || feeRateLess(checked[remaining[best]].fee, checked[remaining[best]].size, candidate.fee, candidate.size) {
+				best = i
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		tx := remaining[best]
+		selected = append(selected, tx)
+		fees += checked[tx].fee
+		size += checked[tx].size
+
+		for _, vin := range tx.Vin {
+			spent[outpointKey(vin.Txid, vin.Vout)] = true
+		}
+		for outIdx, out := range tx.Vout {
+			created[outpointKey(tx.ID, outIdx)] = out
+		}
+
+		remaining = append(remaining[:best:best], remaining[best+1:]...)
+	}
+
+	return selected, fees
+}
+
+//resolveInputs find outputs spent by tx in UTXO set or outputs of selected transactions
+func (u UTXOSet) resolveInputs(tx *Transaction, created map[string]TxOutput, spent map[string]bool) ([]TxOutput, bool) {
+	var spentOutputs []TxOutput
+
+	if !inputsUnspent(tx, spent) {
+		return nil, false
+	}
+
+	for _, vin := range tx.Vin {
+		out, ok := created[outpointKey(vin.Txid, vin.Vout)]
+		if !ok {
+			out, ok = u.FindOutput(vin.Txid, vin.Vout)
+		}
+		if !ok {
+			return nil, false
+		}
+		spentOutputs = append(spentOutputs, out)
+	}
+
+	return spentOutputs, true
+}
+
+//inputsUnspent check no selected transaction spends inputs of tx
+func inputsUnspent(tx *Transaction, spent map[string]bool) bool {
+	for _, vin := range tx.Vin {
+		if spent[outpointKey(vin.Txid, vin.Vout)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//outpointKey identify output by transaction ID and index
+func outpointKey(txID []byte, index int) string {
+	return fmt.Sprintf("%x:%d", txID, index)
+}
@@ -0,0 +1,18 @@
+package parts
+
+import (
+	"testing"
+)
+
+//Fee up to maxMoney times size overflows int, so products must not wrap around
+func TestFeeRateLessLargeFees(t *testing.T) {
+	if !feeRateLess(maxMoney-1, maxBlockSize, maxMoney, maxBlockSize) {
+		t.Fatal("Lower fee of same size is not less")
+	}
+	if feeRateLess(maxMoney, 100, 1, maxBlockSize) {
+		t.Fatal("Highest fee rate is less than lowest")
+	}
+	if feeRateLess(2, 200, 1, 100) || feeRateLess(1, 100, 2, 200) {
+		t.Fatal("Equal fee rates are less than each other")
+	}
+}
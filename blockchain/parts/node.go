@@ -0,0 +1,192 @@
+package parts
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//centralNode is known to every node before peers file is loaded
+const centralNode = "localhost:3000"
+
+//ErrNodeStopped is returned for work given to the message loop after Stop
+var ErrNodeStopped = errors.New("Node is stopped")
+
+//Node is state of a process talking to other nodes
+//Server has blockchain and peers. Light client and CLI have neither and send over a connection per message
+//Messages of every peer are handled one by one in handleMessages, so handlers need no lock except for knownNodes
+//Network and logger belong to the node, so nodes of several networks can run in one process
+type Node struct {
+	Address       string
+	MiningAddress string
+	Network       Network
+
+	dataDir DataDir
+	logger  *log.Logger
+	bc      *BlockChain
+	mempool *Mempool
+	peers   *PeerManager
+	sync    *syncManager
+
+	messages chan message
+	//calls run other work of the node in message loop
+	calls chan func()
+	//snapshotBlocks pass the block snapshot validation waits for from handleBlock
+	//snapshotWant is hash of that block. It is changed only in message loop
+	snapshotBlocks chan *Block
+	snapshotWant   []byte
+
+	quit     chan struct{}
+	stopOnce sync.Once
+
+	mutex      sync.Mutex
+	knownNodes []string
+}
+
+//NewNode make node listening on localhost with port nodeID
+//Blocks are mined to minerAddress when it is not empty. Node talks on the network selected at this time
+func NewNode(nodeID, minerAddress string, dir DataDir) *Node {
+	return &Node{
+		Address:        fmt.Sprintf("localhost:%s", nodeID),
+		MiningAddress:  minerAddress,
+		Network:        ActiveNetwork(),
+		dataDir:        dir,
+		logger:         log.New(os.Stderr, "", log.LstdFlags),
+		mempool:        NewMempool(maxMempoolSize),
+		messages:       make(chan message, peerQueueSize),
+		calls:          make(chan func()),
+		snapshotBlocks: make(chan *Block, 1),
+		quit:           make(chan struct{}),
+		knownNodes:     []string{centralNode},
+	}
+}
+
+//KnownNodes return copy of known nodes. The first one is the central node
+func (n *Node) KnownNodes() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return append([]string{}, n.knownNodes...)
+}
+
+//centralNode return the first known node
+func (n *Node) centralNode() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if len(n.knownNodes) == 0 {
+		return centralNode
+	}
+	return n.knownNodes[0]
+}
+
+func (n *Node) isCentralNode() bool {
+	return n.Address == n.centralNode()
+}
+
+//addNodes append unknown addresses to known nodes and save them in peers file
+//It returns the number of known nodes
+func (n *Node) addNodes(addrs ...string) int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	added := false
+	for _, addr := range addrs {
+		if addr == "" || n.nodeIsKnown(addr) {
+			continue
+		}
+		n.knownNodes = append(n.knownNodes, addr)
+		added = true
+	}
+	if added {
+		n.savePeers()
+	}
+
+	return len(n.knownNodes)
+}
+
+//forgetNode remove unavailable node from known nodes
+func (n *Node) forgetNode(addr string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var updateNodes []string
+
+	for _, node := range n.knownNodes {
+		if node != addr {
+			updateNodes = append(updateNodes, node)
+		}
+	}
+
+	n.knownNodes = updateNodes
+}
+
+//nodeIsKnown must be called with mutex locked
+func (n *Node) nodeIsKnown(addr string) bool {
+	for _, node := range n.knownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+//savePeers keep known nodes in peers file of data directory. It must be called with mutex locked
+func (n *Node) savePeers() {
+	if n.dataDir == "" {
+		return
+	}
+
+	err := n.dataDir.SavePeers(n.knownNodes)
+	if err != nil {
+		fmt.Printf("Cannot save peers: %s\n", err)
+	}
+}
+
+//receive queue message of peer for handleMessages
+//Message arriving after Stop is dropped
+func (n *Node) receive(p *Peer, command string, payload []byte) {
+	select {
+	case n.messages <- message{p, command, payload}:
+	case <-n.quit:
+	}
+}
+
+//run call fn in message loop and return its error
+func (n *Node) run(fn func() error) error {
+	done := make(chan error, 1)
+
+	select {
+	case n.calls <- func() { done <- fn() }:
+		return <-done
+	case <-n.quit:
+		return ErrNodeStopped
+	}
+}
+
+//handleMessages handle messages of every peer in the order they arrived
+//Timeouts of block download are checked between messages. It returns when node stops
+func (n *Node) handleMessages() {
+	ticker := time.NewTicker(syncTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-n.messages:
+			err := n.handleMessage(msg.peer, msg.command, msg.payload)
+			if err != nil {
+				fmt.Printf("Invalid %s message: %s\n", msg.command, err)
+			}
+		case call := <-n.calls:
+			call()
+		case <-ticker.C:
+			n.sync.tick()
+		case <-n.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package parts
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+//startTestNode serve bc on a free port of localhost and know only knownNodes
+func startTestNode(t *testing.T, bc *BlockChain, knownNodes ...string) *Node {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNode("0", "", "")
+	n.Address = ln.Addr().String()
+	n.Network = RegTest
+	n.knownNodes = knownNodes
+	go n.Serve(ln, bc)
+
+	return n
+}
+
+//testChains return chain of height blocks mined to a new wallet, and chains of only its genesis block
+func testChains(t *testing.T, height, copies int) (*BlockChain, []*BlockChain) {
+	wallet := NewWallet()
+	address := string(wallet.GetAddress())
+
+	bc := CreateBlockChainWithStorage(address, NewMemoryStorage())
+	UTXOSet{bc}.Reindex()
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < height; i++ {
+		cbTx := NewCoinbaseTx(address, "", bc.GetBestHeight()+1, 0)
+		_, err = bc.MineBlock([]*Transaction{cbTx})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var others []*BlockChain
+	for i := 0; i < copies; i++ {
+		other := CreateBlockChainFromGenesis(&genesis, NewMemoryStorage())
+		UTXOSet{other}.Reindex()
+		others = append(others, other)
+	}
+
+	return bc, others
+}
+
+//Two nodes behind one node download its blocks at the same time
+func TestNodesSyncBlocks(t *testing.T) {
+	const height = 5
+	bc, others := testChains(t, height, 2)
+
+	source := startTestNode(t, bc)
+	defer source.Stop()
+	for _, other := range others {
+		n := startTestNode(t, other, source.Address)
+		defer n.Stop()
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for _, other := range others {
+		for other.GetBestHeight() < height {
+			if time.Now().After(deadline) {
+				t.Fatalf("Node is at height %d, expected %d", other.GetBestHeight(), height)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		tip, _ := bc.GetBlockHash(height)
+		synced, _ := other.GetBlockHash(height)
+		if !bytes.Equal(tip, synced) {
+			t.Fatalf("Node has block %x at height %d, expected %x", synced, height, tip)
+		}
+	}
+}
+
+//Node of other network is dropped in handshake and gets no blocks
+func TestNodesOfOtherNetwork(t *testing.T) {
+	bc, others := testChains(t, 1, 1)
+
+	source := startTestNode(t, bc)
+	defer source.Stop()
+
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode("0", "", "")
+	n.Address = ln.Addr().String()
+	n.Network = TestNet
+	n.knownNodes = []string{source.Address}
+	go n.Serve(ln, others[0])
+	defer n.Stop()
+
+	time.Sleep(2 * time.Second)
+	if others[0].GetBestHeight() != 0 {
+		t.Fatal("Node of other network is synced")
+	}
+}
+
+//Only the block snapshot validation waits for is passed to it
+func TestSnapshotBlocksOnlyAwaited(t *testing.T) {
+	bc, _ := testChains(t, 2, 0)
+	s, _ := testSyncManager(bc)
+	n := s.node
+
+	err := bc.Db.Update(func(tx StorageTx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(snapshotHashKey), bc.Tip)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(height int) {
+		b, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = n.handleBlock(nil, encodePayload(&block{"", b.Serialize()}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	awaited, _ := bc.GetBlockByHeight(1)
+	n.snapshotWant = awaited.Hash
+	receive(2)
+	receive(1)
+	receive(0)
+
+	if len(n.snapshotBlocks) != 1 {
+		t.Fatalf("%d blocks are passed, expected 1", len(n.snapshotBlocks))
+	}
+	if got := <-n.snapshotBlocks; !bytes.Equal(got.Hash, awaited.Hash) {
+		t.Fatalf("Block %x is passed, expected %x", got.Hash, awaited.Hash)
+	}
+}
@@ -0,0 +1,268 @@
+package parts
+
+//Payloads of network messages in canonical encoding
+//Fields are written in order of the struct with the fields of encoding.go and these ones
+//
+//  string   bytes of UTF-8 text
+//  bool     byte 0 or 1
+//  hashes   uvarint count followed by bytes of every item
+//
+//  verzion     = varint Version, varint BestHeight, string AddrFrom, bool Client
+//  addr        = uvarint len(AddrList), string AddrList...
+//  txProof     = bytes Transaction, bytes BlockHash, varint Proof.Index, hashes Proof.Hashes, uvarint len(Outputs), uvarint Outputs...
+//  mempoolInfo = string AddrFrom, uvarint len(Entries), Entries...
+//  Entry       = bytes TxID, varint Fee, varint Size, varint Time, hashes Depends
+//
+//Other payloads have only string, int(varint), bytes and hashes fields
+
+//payload is a message which writes and reads its own fields
+type payload interface {
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+//encodePayload return canonical encoding of message p
+func encodePayload(p payload) []byte {
+	e := &encoder{}
+	p.encode(e)
+
+	return e.buff.Bytes()
+}
+
+//decodePayload read data into p. Truncated data and trailing bytes are errors
+func decodePayload(data []byte, p payload) error {
+	d := newDecoder(data)
+	p.decode(d)
+
+	return d.finish()
+}
+
+func (e *encoder) putString(s string) {
+	e.putBytes([]byte(s))
+}
+
+func (e *encoder) putBool(b bool) {
+	if b {
+		e.buff.WriteByte(1)
+	} else {
+		e.buff.WriteByte(0)
+	}
+}
+
+func (e *encoder) putHashes(hashes [][]byte) {
+	e.putUvarint(uint64(len(hashes)))
+	for _, hash := range hashes {
+		e.putBytes(hash)
+	}
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) bool() bool {
+	b := d.fixed(1)
+	if d.err != nil {
+		return false
+	}
+	if b[0] > 1 {
+		d.err = errBadEncoding
+	}
+	return b[0] == 1
+}
+
+func (d *decoder) hashes() [][]byte {
+	var hashes [][]byte
+	for i, n := 0, d.count(); i < n; i++ {
+		hashes = append(hashes, d.bytes())
+	}
+	return hashes
+}
+
+func (p *verzion) encode(e *encoder) {
+	e.putVarint(int64(p.Version))
+	e.putVarint(int64(p.BestHeight))
+	e.putString(p.AddrFrom)
+	e.putBool(p.Client)
+}
+
+func (p *verzion) decode(d *decoder) {
+	p.Version = int(d.varint())
+	p.BestHeight = int(d.varint())
+	p.AddrFrom = d.string()
+	p.Client = d.bool()
+}
+
+func (p *addr) encode(e *encoder) {
+	e.putUvarint(uint64(len(p.AddrList)))
+	for _, address := range p.AddrList {
+		e.putString(address)
+	}
+}
+
+func (p *addr) decode(d *decoder) {
+	for i, n := 0, d.count(); i < n; i++ {
+		p.AddrList = append(p.AddrList, d.string())
+	}
+}
+
+func (p *inv) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putString(p.Type)
+	e.putHashes(p.Items)
+}
+
+func (p *inv) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.Type = d.string()
+	p.Items = d.hashes()
+}
+
+func (p *getblocks) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putHashes(p.Locator)
+	e.putBytes(p.StopHash)
+}
+
+func (p *getblocks) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.Locator = d.hashes()
+	p.StopHash = d.bytes()
+}
+
+func (p *getheaders) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putVarint(int64(p.FromHeight))
+	e.putHashes(p.Locator)
+	e.putBytes(p.StopHash)
+}
+
+func (p *getheaders) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.FromHeight = int(d.varint())
+	p.Locator = d.hashes()
+	p.StopHash = d.bytes()
+}
+
+func (p *headers) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putHashes(p.Headers)
+}
+
+func (p *headers) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.Headers = d.hashes()
+}
+
+func (p *getproofs) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putHashes(p.PubKeyHashes)
+}
+
+func (p *getproofs) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.PubKeyHashes = d.hashes()
+}
+
+func (p *txProof) encode(e *encoder) {
+	e.putBytes(p.Transaction)
+	e.putBytes(p.BlockHash)
+	e.putVarint(int64(p.Proof.Index))
+	e.putHashes(p.Proof.Hashes)
+	e.putUvarint(uint64(len(p.Outputs)))
+	for _, index := range p.Outputs {
+		e.putUvarint(uint64(index))
+	}
+}
+
+func (p *txProof) decode(d *decoder) {
+	p.Transaction = d.bytes()
+	p.BlockHash = d.bytes()
+	p.Proof.Index = int(d.varint())
+	p.Proof.Hashes = d.hashes()
+	for i, n := 0, d.count(); i < n; i++ {
+		p.Outputs = append(p.Outputs, int(d.uvarint()))
+	}
+}
+
+func (p *proofs) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putUvarint(uint64(len(p.Proofs)))
+	for i := range p.Proofs {
+		p.Proofs[i].encode(e)
+	}
+}
+
+func (p *proofs) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	for i, n := 0, d.count(); i < n && d.err == nil; i++ {
+		var proof txProof
+		proof.decode(d)
+		p.Proofs = append(p.Proofs, proof)
+	}
+}
+
+func (p *getmempool) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+}
+
+func (p *getmempool) decode(d *decoder) {
+	p.AddrFrom = d.string()
+}
+
+func (p *mempoolInfo) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putUvarint(uint64(len(p.Entries)))
+	for _, entry := range p.Entries {
+		e.putBytes(entry.TxID)
+		e.putVarint(int64(entry.Fee))
+		e.putVarint(int64(entry.Size))
+		e.putVarint(entry.Time)
+		e.putHashes(entry.Depends)
+	}
+}
+
+func (p *mempoolInfo) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	for i, n := 0, d.count(); i < n && d.err == nil; i++ {
+		var entry MempoolEntryInfo
+		entry.TxID = d.bytes()
+		entry.Fee = int(d.varint())
+		entry.Size = int(d.varint())
+		entry.Time = d.varint()
+		entry.Depends = d.hashes()
+		p.Entries = append(p.Entries, entry)
+	}
+}
+
+func (p *getdata) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putString(p.Type)
+	e.putBytes(p.ID)
+}
+
+func (p *getdata) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.Type = d.string()
+	p.ID = d.bytes()
+}
+
+func (p *block) encode(e *encoder) {
+	e.putString(p.AddrFrom)
+	e.putBytes(p.Block)
+}
+
+func (p *block) decode(d *decoder) {
+	p.AddrFrom = d.string()
+	p.Block = d.bytes()
+}
+
+func (p *tx) encode(e *encoder) {
+	e.putString(p.AddFrom)
+	e.putBytes(p.Transaction)
+}
+
+func (p *tx) decode(d *decoder) {
+	p.AddFrom = d.string()
+	p.Transaction = d.bytes()
+}
@@ -0,0 +1,397 @@
+package parts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	peerQueueSize       = 256
+	handshakeTimeout    = 10 * time.Second
+	targetOutboundPeers = 8
+	maxInboundPeers     = 32
+	peerRetryInterval   = 30 * time.Second
+)
+
+//Errors of sending to peer
+var (
+	ErrPeerClosed    = errors.New("Connection to peer is closed")
+	ErrPeerQueueFull = errors.New("Peer does not read messages fast enough")
+)
+
+//message is a command received from peer, or queued to be sent to it
+type message struct {
+	peer    *Peer
+	command string
+	payload []byte
+}
+
+//Peer is a long-lived connection to other node
+//One goroutine writes messages from queue, and another one reads messages and passes them to handler
+//Both sides send version first and answer version with verack. Other messages are accepted only after version
+type Peer struct {
+	Inbound bool
+	//addr is listening address of the peer. It is guarded by mutex of manager
+	addr string
+
+	manager   *PeerManager
+	conn      net.Conn
+	queue     chan message
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	//version and verack are touched only by read goroutine
+	version *verzion
+	verack  bool
+	ready   chan struct{}
+}
+
+func newPeer(pm *PeerManager, conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{
+		Inbound: inbound,
+		addr:    addr,
+		manager: pm,
+		conn:    conn,
+		queue:   make(chan message, peerQueueSize),
+		quit:    make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+}
+
+//Addr return listening address of the peer. It is empty until inbound peer sends version
+func (p *Peer) Addr() string {
+	p.manager.mutex.Lock()
+	defer p.manager.mutex.Unlock()
+
+	return p.addr
+}
+
+func (p *Peer) String() string {
+	if addr := p.Addr(); addr != "" {
+		return addr
+	}
+	return p.conn.RemoteAddr().String()
+}
+
+//start run read and write goroutines and drop the peer when handshake does not finish in time
+func (p *Peer) start() {
+	go p.writeLoop()
+	go p.readLoop()
+
+	time.AfterFunc(handshakeTimeout, func() {
+		select {
+		case <-p.ready:
+		default:
+			fmt.Printf("Handshake with %s timed out\n", p)
+			p.Close()
+		}
+	})
+}
+
+//Send queue message without waiting for the peer
+//Peer whose queue is full is disconnected instead of blocking the caller
+func (p *Peer) Send(command string, payload []byte) error {
+	if p.closed() {
+		return ErrPeerClosed
+	}
+
+	select {
+	case p.queue <- message{p, command, payload}:
+		return nil
+	default:
+		p.Close()
+		return ErrPeerQueueFull
+	}
+}
+
+//Close close connection and remove the peer from its manager. It is safe to call many times
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+		p.manager.remove(p)
+	})
+}
+
+func (p *Peer) closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case msg := <-p.queue:
+			p.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+			err := writeMessage(p.conn, p.manager.node.Network, msg.command, msg.payload)
+			if err != nil {
+				fmt.Printf("Cannot send %s to %s: %s\n", msg.command, p, err)
+				p.Close()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+//readLoop read messages until the connection is closed
+//Connection is dropped at the first broken message because the next one cannot be found
+func (p *Peer) readLoop() {
+	defer p.Close()
+
+	for {
+		command, payload, err := readMessage(p.conn, p.manager.node.Network)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			select {
+			case <-p.quit:
+			default:
+				fmt.Printf("Dropping connection to %s: %s\n", p, err)
+			}
+			return
+		}
+		fmt.Printf("Received %s command from %s\n", command, p)
+
+		switch {
+		case command == "version":
+			err = p.handleVersion(payload)
+		case command == "verack":
+			err = p.handleVerack()
+		case p.version == nil:
+			err = fmt.Errorf("%s before version", command)
+		default:
+			p.manager.node.receive(p, command, payload)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Dropping connection to %s: %s\n", p, err)
+			return
+		}
+	}
+}
+
+//handleVersion answer version of the peer with verack
+//Inbound peer learns our version here, and it is known by its address from now on
+func (p *Peer) handleVersion(request []byte) error {
+	if p.version != nil {
+		return errors.New("Duplicate version")
+	}
+
+	var payload verzion
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	if payload.Version != nodeVersion {
+		return fmt.Errorf("Unsupported protocol version %d", payload.Version)
+	}
+	p.version = &payload
+
+	if p.Inbound {
+		if !payload.Client {
+			p.manager.register(p, payload.AddrFrom)
+		}
+		p.Send("version", p.manager.node.newVersion())
+	}
+	p.Send("verack", nil)
+	p.checkReady()
+
+	p.manager.node.receive(p, "version", request)
+	return nil
+}
+
+func (p *Peer) handleVerack() error {
+	if p.verack {
+		return errors.New("Duplicate verack")
+	}
+	p.verack = true
+	p.checkReady()
+
+	return nil
+}
+
+func (p *Peer) checkReady() {
+	if p.version != nil && p.verack {
+		close(p.ready)
+	}
+}
+
+//PeerManager keep connections to other nodes
+//It dials known nodes until targetOutboundPeers connections are open, and accepts up to maxInboundPeers connections
+type PeerManager struct {
+	node *Node
+
+	mutex sync.Mutex
+	peers map[string]*Peer
+	//all has every open peer including clients and peers whose address is not registered
+	all map[*Peer]bool
+	//inbound peers are counted from accept, before their address is known
+	inbound int
+}
+
+//NewPeerManager create manager passing messages of peers to node
+func NewPeerManager(node *Node) *PeerManager {
+	return &PeerManager{
+		node:  node,
+		peers: make(map[string]*Peer),
+		all:   make(map[*Peer]bool),
+	}
+}
+
+//Peer return connected peer of addr or nil
+func (pm *PeerManager) Peer(addr string) *Peer {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	return pm.peers[addr]
+}
+
+//Peers return every connected peer whose address is known
+func (pm *PeerManager) Peers() []*Peer {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	var peers []*Peer
+	for _, peer := range pm.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+//Connect return peer of addr, dialing it when there is no connection yet
+//Messages sent before the handshake finishes are queued after version
+func (pm *PeerManager) Connect(addr string) (*Peer, error) {
+	if peer := pm.Peer(addr); peer != nil {
+		return peer, nil
+	}
+
+	conn, err := net.DialTimeout(protocol, addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.mutex.Lock()
+	if peer, ok := pm.peers[addr]; ok {
+		pm.mutex.Unlock()
+		conn.Close()
+		return peer, nil
+	}
+	peer := newPeer(pm, conn, addr, false)
+	pm.peers[addr] = peer
+	pm.all[peer] = true
+	pm.mutex.Unlock()
+
+	peer.Send("version", pm.node.newVersion())
+	peer.start()
+
+	return peer, nil
+}
+
+//Accept start inbound peer of conn, or close it when there are too many inbound peers
+func (pm *PeerManager) Accept(conn net.Conn) {
+	pm.mutex.Lock()
+	if pm.inbound >= maxInboundPeers {
+		pm.mutex.Unlock()
+		fmt.Printf("Rejecting %s: too many inbound peers\n", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	pm.inbound++
+	peer := newPeer(pm, conn, "", true)
+	pm.all[peer] = true
+	pm.mutex.Unlock()
+
+	peer.start()
+}
+
+//register make inbound peer reachable by the address it claims
+//Address of connected peer is never taken over, so a peer cannot receive messages meant for another node
+//by claiming its address. Clients and second connections are served on their own connection but not registered
+func (pm *PeerManager) register(p *Peer, addr string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if _, ok := pm.peers[addr]; ok || addr == "" {
+		return
+	}
+	p.addr = addr
+	pm.peers[addr] = p
+}
+
+func (pm *PeerManager) remove(p *Peer) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if pm.peers[p.addr] == p {
+		delete(pm.peers, p.addr)
+	}
+	delete(pm.all, p)
+	if p.Inbound {
+		pm.inbound--
+	}
+}
+
+func (pm *PeerManager) outboundCount() int {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	count := 0
+	for _, peer := range pm.peers {
+		if !peer.Inbound {
+			count++
+		}
+	}
+	return count
+}
+
+//Maintain dial known nodes whenever there are less than targetOutboundPeers outbound peers
+//Unavailable nodes are forgotten. It returns when node stops
+func (pm *PeerManager) Maintain() {
+	for {
+		for _, node := range pm.node.KnownNodes() {
+			if pm.outboundCount() >= targetOutboundPeers {
+				break
+			}
+			if node == pm.node.Address || pm.Peer(node) != nil {
+				continue
+			}
+
+			_, err := pm.Connect(node)
+			if err != nil {
+				fmt.Printf("%s is not available\n", node)
+				pm.node.forgetNode(node)
+			}
+		}
+
+		select {
+		case <-time.After(peerRetryInterval):
+		case <-pm.node.quit:
+			return
+		}
+	}
+}
+
+//closeAll close every open peer
+func (pm *PeerManager) closeAll() {
+	pm.mutex.Lock()
+	var peers []*Peer
+	for peer := range pm.all {
+		peers = append(peers, peer)
+	}
+	pm.mutex.Unlock()
+
+	for _, peer := range peers {
+		peer.Close()
+	}
+}
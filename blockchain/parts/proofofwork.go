@@ -1,16 +1,13 @@
 package parts
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"fmt"
 	"math"
 	"math/big"
 )
 
 const (
-	targetBits		= 16
-	maxNonce	= math.MaxInt64
+	maxNonce = math.MaxInt64
 )
 
 //ProofOfWork define basic struct for PoW
@@ -20,49 +17,37 @@ type ProofOfWork struct {
 }
 
 //NewProofOfWork initialize ProofOfWork struct
+//Target is decoded from compact Bits of block
 func NewProofOfWork(b *Block) *ProofOfWork {
-	//bit move uint(256-targetBits) step to right
-	//000...00100000...00
-	//24 zeros + 1 + 231 zeros -> 6 leading zero in hex
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
-//prepareData is concatnate block information to []byte type data
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevBlockHash,
-			pow.Block.HashTransactions(),
-			IntToHex(pow.Block.TimeStamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+//prepareData return header of block with nonce
+func (pow *ProofOfWork) prepareData(nonce int) *BlockHeader {
+	header := pow.Block.BlockHeader
+	header.Nonce = nonce
+
+	return &header
 }
 
 //Run Proof of Work
 func (pow *ProofOfWork) Run() (int, []byte) {
 	var hashInt big.Int
-	var hash [32]byte
+	var hash []byte
 	nonce := 0
 
 	fmt.Printf("mining a new block")
 	//To avoid overflow of nonce
 	for nonce < maxNonce {
-		data := pow.prepareData(nonce)
-		hash = sha256.Sum256(data)
+		hash = pow.prepareData(nonce).BlockHash()
 		//%x : base 16, with lower-case letters for a-f
 		//\r : Carriage return
 		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
+		hashInt.SetBytes(hash)
 
 		// x.Cmp(y)
 		//   -1 if x <  y
@@ -76,18 +61,31 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	}
 	fmt.Print("\n\n")
 
-	return nonce, hash[:]
+	return nonce, hash
+}
+
+//Hash return hash of block header with nonce
+func (pow *ProofOfWork) Hash(nonce int) []byte {
+	return pow.prepareData(nonce).BlockHash()
 }
 
 //Validate proof of work
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.Block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	hash := pow.Hash(pow.Block.Nonce)
+	hashInt.SetBytes(hash)
 
 	isValid := hashInt.Cmp(pow.Target) == -1
 
 	return isValid
 }
+
+//Work return expected number of hashes to find a block under the target
+//work = 2^256 / (target + 1)
+func (pow *ProofOfWork) Work() *big.Int {
+	denominator := new(big.Int).Add(pow.Target, big.NewInt(1))
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	return work.Div(work, denominator)
+}
@@ -0,0 +1,137 @@
+package parts
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//minPruneDepth keep enough blocks for reorganization and difficulty retarget
+const minPruneDepth = 2 * retargetInterval
+
+//Keys of meta bucket for pruning
+//pruneDepth is number of recent blocks kept with transactions, prunedHeight is the highest pruned block
+const (
+	pruneDepthKey   = "prune"
+	prunedHeightKey = "prunedheight"
+)
+
+//ErrBlockPruned means transactions of the block were deleted by pruning
+var ErrBlockPruned = errors.New("Block is pruned")
+
+//IsPruned check only header of block is stored
+//Every valid block has coinbase, so a block without transactions is a pruned one
+func (b *Block) IsPruned() bool {
+	return len(b.Transactions) == 0
+}
+
+//EnablePrune keep transactions and undo data of only the last depth main chain blocks
+//Older blocks are replaced with their headers. It cannot be turned off once blocks are pruned
+func (bc *BlockChain) EnablePrune(depth int) error {
+	if depth < minPruneDepth {
+		return fmt.Errorf("Prune depth must be at least %d", minPruneDepth)
+	}
+
+	err := bc.Db.Update(func(tx StorageTx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaBucket))
+		if err != nil {
+			return err
+		}
+
+		err = meta.Put([]byte(pruneDepthKey), heightKey(depth))
+		if err != nil {
+			return err
+		}
+
+		bc.pruneDepth = depth
+		return bc.pruneBlocks(tx)
+	})
+	if err != nil {
+		bc.pruneDepth = 0
+	}
+	return err
+}
+
+//PruneDepth return number of recent blocks kept with transactions. It is 0 when pruning is off
+func (bc *BlockChain) PruneDepth() int {
+	return bc.pruneDepth
+}
+
+//PrunedHeight return the highest pruned block. It is -1 when no block is pruned
+func (bc *BlockChain) PrunedHeight() int {
+	prunedHeight := -1
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		prunedHeight = readPrunedHeight(tx)
+		return nil
+	})
+	if err != nil {
+		return -1
+	}
+	return prunedHeight
+}
+
+//loadPrune read prune depth recorded in meta bucket
+func (bc *BlockChain) loadPrune() error {
+	return bc.Db.View(func(tx StorageTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil {
+			return nil
+		}
+
+		if depth := meta.Get([]byte(pruneDepthKey)); depth != nil {
+			bc.pruneDepth = int(binary.BigEndian.Uint64(depth))
+		}
+		return nil
+	})
+}
+
+func readPrunedHeight(tx StorageTx) int {
+	meta := tx.Bucket([]byte(metaBucket))
+	if meta == nil {
+		return -1
+	}
+
+	prunedHeight := meta.Get([]byte(prunedHeightKey))
+	if prunedHeight == nil {
+		return -1
+	}
+	return int(binary.BigEndian.Uint64(prunedHeight))
+}
+
+//pruneBlocks replace main chain blocks below the prune window with headers and delete their undo data
+//It must be called in db transaction after main chain is changed
+func (bc *BlockChain) pruneBlocks(tx StorageTx) error {
+	if bc.pruneDepth == 0 {
+		return nil
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	hb := tx.Bucket([]byte(heightBucket))
+	ub := tx.Bucket([]byte(undoBucket))
+
+	tipHeight := int(binary.BigEndian.Uint64(b.Get([]byte("h"))))
+	pruneTo := tipHeight - bc.pruneDepth
+	prunedHeight := readPrunedHeight(tx)
+	if pruneTo <= prunedHeight {
+		return nil
+	}
+
+	for height := prunedHeight + 1; height <= pruneTo; height++ {
+		hash := hb.Get(heightKey(height))
+		block := DeserializeBlock(b.Get(hash))
+
+		err := b.Put(block.Hash, block.Header().Serialize())
+		if err != nil {
+			return err
+		}
+		if ub != nil {
+			err = ub.Delete(block.Hash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Bucket([]byte(metaBucket)).Put([]byte(prunedHeightKey), heightKey(pruneTo))
+}
@@ -0,0 +1,143 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"math/big"
+)
+
+const maxOrphanBlocks = 100
+
+//initChainWork store cumulative work of every main chain block
+//It does nothing when work of tip is already stored
+func initChainWork(tx StorageTx, tip []byte) error {
+	w, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return err
+	}
+	if w.Get(tip) != nil {
+		return nil
+	}
+
+	var chain []*Block
+	b := tx.Bucket([]byte(blocksBucket))
+	for hash := tip; len(hash) > 0; {
+		block := DeserializeBlock(b.Get(hash))
+		chain = append(chain, block)
+		hash = block.PrevBlockHash
+	}
+
+	//Accumulate from genesis to tip
+	work := big.NewInt(0)
+	for i := len(chain) - 1; i >= 0; i-- {
+		work.Add(work, NewProofOfWork(chain[i]).Work())
+		err = w.Put(chain[i].Hash, work.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//chainWork return cumulative work of stored block. It is zero for unknown hash
+func (bc *BlockChain) chainWork(hash []byte) *big.Int {
+	work := new(big.Int)
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		w := tx.Bucket([]byte(chainWorkBucket))
+		if w != nil {
+			work.SetBytes(w.Get(hash))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return work
+}
+
+//findFork walk back from old tip and new block until both branches meet
+//disconnect is ordered from old tip to fork point, connect is ordered from fork point to new block
+func findFork(b StorageBucket, tipHash []byte, block *Block) ([]*Block, []*Block) {
+	var disconnect []*Block
+	var connect []*Block
+
+	oldBlock := DeserializeBlock(b.Get(tipHash))
+	newBlock := block
+
+	for oldBlock.Height > newBlock.Height {
+		disconnect = append(disconnect, oldBlock)
+		oldBlock = DeserializeBlock(b.Get(oldBlock.PrevBlockHash))
+	}
+	for newBlock.Height > oldBlock.Height {
+		connect = append([]*Block{newBlock}, connect...)
+		newBlock = DeserializeBlock(b.Get(newBlock.PrevBlockHash))
+	}
+	for bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+		if len(oldBlock.PrevBlockHash) == 0 || len(newBlock.PrevBlockHash) == 0 {
+			log.Panic("ERROR: Branches do not share genesis block")
+		}
+		disconnect = append(disconnect, oldBlock)
+		connect = append([]*Block{newBlock}, connect...)
+		oldBlock = DeserializeBlock(b.Get(oldBlock.PrevBlockHash))
+		newBlock = DeserializeBlock(b.Get(newBlock.PrevBlockHash))
+	}
+
+	return disconnect, connect
+}
+
+//orphanedTransactions return non coinbase transactions of disconnected blocks
+//which are not included again in connected blocks
+//They are ordered from fork point so that parents come before children
+func orphanedTransactions(disconnect, connect []*Block) []*Transaction {
+	var orphaned []*Transaction
+	included := make(map[string]bool)
+
+	for _, block := range connect {
+		for _, tx := range block.Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	for i := len(disconnect) - 1; i >= 0; i-- {
+		for _, tx := range disconnect[i].Transactions {
+			if tx.IsCoinbase() || included[hex.EncodeToString(tx.ID)] {
+				continue
+			}
+			orphaned = append(orphaned, tx)
+		}
+	}
+
+	return orphaned
+}
+
+//addOrphan keep block whose parent is unknown
+//It must be called in db transaction of AddBlock
+func (bc *BlockChain) addOrphan(block *Block) {
+	if len(bc.orphans) >= maxOrphanBlocks {
+		//Drop any one to limit memory
+		for hash := range bc.orphans {
+			delete(bc.orphans, hash)
+			break
+		}
+	}
+	bc.orphans[hex.EncodeToString(block.Hash)] = block
+}
+
+//takeOrphans remove orphans whose parent is parentHash and return them
+//It must be called after parentHash is stored
+func (bc *BlockChain) takeOrphans(parentHash []byte) []*Block {
+	var children []*Block
+
+	for hash, orphan := range bc.orphans {
+		if bytes.Compare(orphan.PrevBlockHash, parentHash) == 0 {
+			children = append(children, orphan)
+			delete(bc.orphans, hash)
+		}
+	}
+
+	return children
+}
@@ -2,61 +2,240 @@ package parts
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"time"
 )
 
-func gobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
+//requestBlocks ask blocks after our main chain to every connected peer
+func (n *Node) requestBlocks() {
+	for _, peer := range n.peers.Peers() {
+		n.sendGetBlocks(peer)
+	}
+}
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
+//dialClient connect to addr and finish handshake as a client
+//Connection has deadline of requestTimeout from now
+func (n *Node) dialClient(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(protocol, addr, handshakeTimeout)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err == nil {
+		err = writeMessage(conn, n.Network, "version", n.newVersion())
+	}
+	if err == nil {
+		_, err = n.readReply(conn, "verack")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Handshake with %s failed: %s", addr, err)
 	}
 
-	return buff.Bytes()
+	return conn, nil
 }
 
-func nodeIsKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
+//requestData send payload of command to addr and wait until reply command arrives on the same connection
+//It returns payload of the reply. It is used by processes which do not run a server such as light client
+func (n *Node) requestData(addr, command string, payload []byte, reply string) ([]byte, error) {
+	conn, err := n.dialClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = writeMessage(conn, n.Network, command, payload)
+	if err != nil {
+		return nil, err
 	}
 
-	return false
+	data, err := n.readReply(conn, reply)
+	if err != nil {
+		return nil, fmt.Errorf("No %s reply from %s: %s", reply, addr, err)
+	}
+	return data, nil
 }
 
-func requestBlocks() {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+//readReply read messages of conn until reply command arrives
+//Version of the node is answered with verack, and other messages are skipped
+func (n *Node) readReply(conn net.Conn, reply string) ([]byte, error) {
+	for {
+		command, payload, err := readMessage(conn, n.Network)
+		if err != nil {
+			return nil, err
+		}
+		if command == reply {
+			return payload, nil
+		}
+		if command == "version" {
+			err = writeMessage(conn, n.Network, "verack", nil)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 }
 
-//StartServer start server
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
+//StartServer open blockchain of data directory and serve peers
+//Known nodes are restored from peers file and logs of the node are written to log file
+//indexes are enabled in addition to ones enabled by previous runs, and blocks are pruned when prune is not 0
+func (n *Node) StartServer(indexes []string, prune int) {
+	logOutput, err := os.OpenFile(n.dataDir.LogFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer ln.Close()
+	defer logOutput.Close()
+	n.logger = log.New(io.MultiWriter(os.Stderr, logOutput), "", log.LstdFlags)
+
+	peers, err := n.dataDir.LoadPeers()
+	if err != nil {
+		n.logger.Panic(err)
+	}
+	n.addNodes(peers...)
+
+	ln, err := net.Listen(protocol, n.Address)
+	if err != nil {
+		n.logger.Panic(err)
+	}
+	n.logger.Printf("Node %s started with data directory %s", n.Address, n.dataDir)
+
+	bc := NewBlockChain(n.dataDir)
+	for _, name := range indexes {
+		fmt.Printf("Enabling %s...\n", name)
+		err = bc.EnableIndex(name)
+		if err != nil {
+			n.logger.Panic(err)
+		}
+	}
+	if prune > 0 {
+		err = bc.EnablePrune(prune)
+		if err != nil {
+			n.logger.Panic(err)
+		}
+	}
+
+	n.Serve(ln, bc)
+}
 
-	bc := NewBlockChain(nodeID)
+//Serve accept peers from ln and keep bc in sync with them until Stop is called
+//Blockchain is changed only by message loop of the node from now on
+func (n *Node) Serve(ln net.Listener, bc *BlockChain) {
+	bc.Subscribe(func(update *ChainUpdate) {
+		n.mempool.Update(update, UTXOSet{bc})
+	})
+	n.bc = bc
 
-	if nodeAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+	n.sync = newSyncManager(n)
+	n.peers = NewPeerManager(n)
+	go func() {
+		<-n.quit
+		ln.Close()
+		n.peers.closeAll()
+	}()
+	go n.handleMessages()
+	go n.peers.Maintain()
+	if bc.SnapshotHeight() >= 0 {
+		go n.validateSnapshot()
 	}
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Panic(err)
+			select {
+			case <-n.quit:
+				return
+			default:
+				n.logger.Panic(err)
+			}
+		}
+		n.peers.Accept(conn)
+	}
+}
+
+//Stop make Serve close listener and every peer, and end message loop of the node
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.quit)
+	})
+}
+
+//validateSnapshot download blocks up to snapshot block from the central node and replay them
+//Node keeps running on the snapshot meanwhile, but it stops when the snapshot turns out to be invalid
+//Replay only reads blockchain, and its result is stored by message loop so no block is added at the same time
+func (n *Node) validateSnapshot() {
+	fetch := func(hash []byte) (*Block, error) {
+		central := n.centralNode()
+		err := n.run(func() error {
+			peer := n.peers.Peer(central)
+			if peer == nil {
+				return fmt.Errorf("%s is not connected", central)
+			}
+			n.snapshotWant = hash
+			n.sendGetData(peer, "block", hash)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		timeout := time.After(requestTimeout)
+		for {
+			select {
+			case block := <-n.snapshotBlocks:
+				if bytes.Equal(block.Hash, hash) {
+					return block, nil
+				}
+			case <-timeout:
+				return nil, fmt.Errorf("Block %x is not received from %s", hash, central)
+			}
 		}
-		go handleConnection(conn, bc)
 	}
+
+	for {
+		n.logger.Printf("Validating snapshot at height %d", n.bc.SnapshotHeight())
+		commit, err := n.bc.ValidateSnapshot(fetch)
+		if err == nil {
+			err = n.run(commit)
+		}
+		if err == nil {
+			n.logger.Printf("Snapshot is valid")
+			return
+		}
+		if err == ErrSnapshotMismatch {
+			n.logger.Panic(err)
+		}
+		if err == ErrNodeStopped {
+			return
+		}
+
+		n.logger.Printf("Cannot validate snapshot: %s", err)
+		select {
+		case <-time.After(requestTimeout):
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+//requestMempool ask entries of mempool to node at addr
+func (n *Node) requestMempool(addr string) ([]MempoolEntryInfo, error) {
+	payload := encodePayload(&getmempool{n.Address})
+
+	data, err := n.requestData(addr, "getmempool", payload, "mempool")
+	if err != nil {
+		return nil, err
+	}
+
+	var reply mempoolInfo
+	err = decodePayload(data, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Entries, nil
 }
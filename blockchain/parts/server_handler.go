@@ -2,256 +2,370 @@ package parts
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net"
 )
 
-func handleConnection(conn net.Conn, bc *BlockChain) {
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
-	}
-	command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command \n", command)
-
+//handleMessage pass payload of command received from peer to its handler
+//Handlers reply to the peer, not to AddrFrom of the payload which anyone can fill
+func (n *Node) handleMessage(from *Peer, command string, payload []byte) error {
 	switch command {
 	case "addr":
-		handleAddr(request)
+		return n.handleAddr(from, payload)
 	case "block":
-		handleBlock(request, bc)
+		return n.handleBlock(from, payload)
 	case "inv":
-		handleInv(request, bc)
+		return n.handleInv(from, payload)
 	case "getblocks":
-		handleGetBlocks(request, bc)
+		return n.handleGetBlocks(from, payload)
 	case "getdata":
-		handleGetData(request, bc)
+		return n.handleGetData(from, payload)
+	case "getheaders":
+		return n.handleGetHeaders(from, payload)
+	case "getmempool":
+		return n.handleGetMempool(from, payload)
+	case "headers":
+		return n.handleHeaders(from, payload)
+	case "getproofs":
+		return n.handleGetProofs(from, payload)
 	case "tx":
-		handleTx(request, bc)
+		return n.handleTx(from, payload)
 	case "version":
-		handleVersion(request, bc)
+		return n.handleVersion(from, payload)
 	default:
 		fmt.Println("Unknown command!")
 	}
-	conn.Close()
+
+	return nil
 }
 
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
+func (n *Node) handleAddr(from *Peer, request []byte) error {
 	var payload addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
-	requestBlocks()
+	count := n.addNodes(payload.AddrList...)
+	fmt.Printf("There are %d known nodes now!\n", count)
+	n.requestBlocks()
+
+	return nil
 }
 
-func handleBlock(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleBlock(from *Peer, request []byte) error {
 	var payload block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	blockData := payload.Block
-	block := DeserializeBlock(blockData)
+	block, err := decodeBlock(blockData)
+	if err != nil {
+		return err
+	}
 
 	fmt.Println("received a new block!")
-	bc.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	//Blocks below snapshot block are requested by snapshot validation
+	//Only the block it waits for is passed, replacing one left by a request which timed out
+	if block.Height <= n.bc.SnapshotHeight() {
+		if bytes.Equal(block.Hash, n.snapshotWant) {
+			n.snapshotWant = nil
+			select {
+			case <-n.snapshotBlocks:
+			default:
+			}
+			n.snapshotBlocks <- block
+		}
+		return nil
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	//Blocks of header chain are connected in order of height
+	if n.sync.onBlock(from, block) {
+		return nil
+	}
 
-		blocksInTransit = blocksInTransit[1:]
+	//mempool follows main chain through subscription of StartServer
+	err = n.bc.AddBlock(block)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
 	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+		fmt.Printf("Added block %x\n", block.Hash)
 	}
+
+	return nil
 }
 
-func handleInv(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleInv(from *Peer, request []byte) error {
 	var payload inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	fmt.Printf("received inventory with %d %s\n", len(payload.Items), payload.Type)
+	if len(payload.Items) == 0 {
+		return nil
+	}
 
+	//Unknown block is downloaded after its header, so headers are asked instead
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockhash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockhash)
-
-		newInTransit := [][]byte{}
-
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockhash) != 0 {
-				newInTransit = append(newInTransit, b)
+		for _, hash := range payload.Items {
+			if n.sync.lookup(hash) == nil {
+				n.sync.requestHeaders(from)
+				break
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.AddrFrom, "tx", txID)
+		if !n.mempool.Has(txID) {
+			n.sendGetData(from, "tx", txID)
 		}
 	}
+
+	return nil
 }
 
-func handleGetBlocks(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleGetBlocks(from *Peer, request []byte) error {
 	var payload getblocks
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	hashes := n.bc.LocateHashes(payload.Locator, payload.StopHash, maxInvPerMsg)
+	n.sendInv(from, "block", hashes)
+
+	return nil
+}
+
+func (n *Node) handleGetHeaders(from *Peer, request []byte) error {
+	var payload getheaders
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	var blocks []*Block
+	if len(payload.Locator) > 0 {
+		blocks = n.bc.LocateHeaders(payload.Locator, payload.StopHash, maxHeadersPerMsg)
+	} else {
+		blocks = n.bc.GetHeaders(payload.FromHeight, maxHeadersPerMsg)
+	}
+	n.sendHeaders(from, blocks)
+
+	return nil
+}
+
+//handleHeaders extend header chain with headers asked by sync
+func (n *Node) handleHeaders(from *Peer, request []byte) error {
+	var payload headers
+
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
+	var blocks []*Block
+	for _, data := range payload.Headers {
+		header, err := decodeBlock(data)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, header)
+	}
+
+	fmt.Printf("received %d headers\n", len(blocks))
+	return n.sync.onHeaders(from, blocks)
 }
 
-func handleGetData(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleGetMempool(from *Peer, request []byte) error {
+	var payload getmempool
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	n.sendMempool(from)
+
+	return nil
+}
+
+//handleGetProofs reply unspent outputs of requested keys with merkle proofs of their transactions
+func (n *Node) handleGetProofs(from *Peer, request []byte) error {
+	var payload getproofs
+	var txProofs []txProof
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := UTXOSet{n.bc}
+	for _, pubKeyHash := range payload.PubKeyHashes {
+		for txID, outs := range UTXOSet.FindUnspentIndexes(pubKeyHash) {
+			ID, err := hex.DecodeString(txID)
+			if err != nil {
+				log.Panic(err)
+			}
+
+			block, err := n.bc.FindTransactionBlock(ID)
+			if err != nil {
+				fmt.Printf("Transaction %s of UTXO set is not found\n", txID)
+				continue
+			}
+			proof, err := block.MerkleProof(ID)
+			if err != nil {
+				fmt.Printf("Cannot prove transaction %s: %s\n", txID, err)
+				continue
+			}
+
+			tx := block.Transactions[proof.Index]
+			txProofs = append(txProofs, txProof{tx.Serialize(), block.Hash, *proof, outs})
+		}
+	}
+
+	n.sendProofs(from, txProofs)
+
+	return nil
+}
+
+func (n *Node) handleGetData(from *Peer, request []byte) error {
 	var payload getdata
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	if payload.Type == "block" {
-		block, err := bc.GetBlock([]byte(payload.ID))
+		block, err := n.bc.GetBlock([]byte(payload.ID))
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+
+		//Pruned node cannot serve old blocks
+		if block.IsPruned() {
+			fmt.Printf("Block %x is pruned\n", block.Hash)
+			return nil
 		}
 
-		sendBlock(payload.AddrFrom, &block)
+		n.sendBlock(from, &block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := n.mempool.Get(payload.ID)
+		if !ok {
+			fmt.Printf("Transaction %x is not in mempool\n", payload.ID)
+			return nil
+		}
 
-		sendTx(payload.AddrFrom, &tx)
+		n.sendTx(from, &tx)
 	}
+
+	return nil
 }
 
-func handleTx(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleTx(from *Peer, request []byte) error {
 	var payload tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := decodeTransaction(txData)
+	if err != nil {
+		return err
+	}
+
+	//Invalid or conflicting transaction is neither relayed nor mined
+	err = n.mempool.Add(tx, UTXOSet{n.bc})
+	if err != nil {
+		fmt.Printf("Rejected transaction %x: %s\n", tx.ID, err)
+		return nil
+	}
 
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != payload.AddFrom {
-				sendInv(node, "tx", [][]byte{tx.ID})
+	if n.isCentralNode() {
+		for _, peer := range n.peers.Peers() {
+			if peer != from {
+				n.sendInv(peer, "tx", [][]byte{tx.ID})
 			}
 		}
 	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
+		if n.mempool.Count() >= 2 && len(n.MiningAddress) > 0 {
 		MineTransactions:
-			var txs []*Transaction
+			pool := n.mempool.Transactions()
 
-			for id := range mempool {
-				tx := mempool[id]
-				if bc.VerifyTransaction(&tx) {
-					txs = append(txs, &tx)
-				}
-			}
+			//Transactions with higher fee rate go first
+			UTXOSet := UTXOSet{n.bc}
+			txs, fees := UTXOSet.SelectTransactions(pool)
 
 			if len(txs) == 0 {
 				fmt.Println("All transactions are invalid! Waiting for new ones...")
-				return
+				return nil
 			}
 
-			cbTx := NewCoinbaseTx(miningAddress, "")
-			txs = append(txs, cbTx)
+			//Coinbase must be the first transaction
+			cbTx := NewCoinbaseTx(n.MiningAddress, "", n.bc.GetBestHeight()+1, fees)
+			txs = append([]*Transaction{cbTx}, txs...)
 
-			newBlock := bc.MineBlock(txs)
-			UTXOSet := UTXOSet{bc}
-			UTXOSet.Reindex()
+			newBlock, err := n.bc.MineBlock(txs)
+			if err != nil {
+				fmt.Printf("Mined block is invalid: %s\n", err)
+				return nil
+			}
 
+			//Mined transactions are removed from mempool by AddBlock
 			fmt.Println("New block is mined!")
 
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
+			for _, peer := range n.peers.Peers() {
+				n.sendInv(peer, "block", [][]byte{newBlock.Hash})
 			}
 
-			for _, node := range knownNodes {
-				if node != nodeAddress {
-					sendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
-
-			if len(mempool) > 0 {
+			if n.mempool.Count() > 0 {
 				goto MineTransactions
 			}
 		}
 	}
+
+	return nil
 }
 
-func handleVersion(request []byte, bc *BlockChain) {
-	var buff bytes.Buffer
+func (n *Node) handleVersion(from *Peer, request []byte) error {
 	var payload verzion
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	myBestHeight := bc.GetBestHeight()
+	if payload.Client {
+		return nil
+	}
+
+	//Both sides send version in handshake, so only the one behind asks for headers
+	n.sync.setPeerHeight(from, payload.BestHeight)
+	myBestHeight := n.sync.bestHeight()
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.AddrFrom, bc)
+		n.sync.requestHeaders(from)
 	}
 
-	if !nodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
-	}
+	n.addNodes(payload.AddrFrom)
+
+	return nil
 }
@@ -1,92 +1,108 @@
 package parts
 
-import (
-	"bytes"
-	"fmt"
-	"io"
-	"log"
-	"net"
-)
-
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updateNodes []string
-
-		for _, node := range knownNodes {
-			if node != addr {
-				updateNodes = append(updateNodes, node)
-			}
-		}
-
-		knownNodes = updateNodes
+import "fmt"
 
-		return
+//sendData queue payload of command to peer
+//Replies go to the peer which asked, so they never open a connection to an address taken from a payload
+func (n *Node) sendData(p *Peer, command string, payload []byte) {
+	err := p.Send(command, payload)
+	if err != nil {
+		fmt.Printf("Cannot send %s to %s: %s\n", command, p, err)
 	}
-	defer conn.Close()
+}
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+//sendOnce finish handshake as a client, send a message and close connection
+func (n *Node) sendOnce(addr, command string, payload []byte) error {
+	conn, err := n.dialClient(addr)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-}
+	defer conn.Close()
 
-func sendAddr(address string) {
-	nodes := addr{knownNodes}
-	nodes.AddrList = append(nodes.AddrList, nodeAddress)
-	payload := gobEncode(nodes)
-	request := append(commandToBytes("addr"), payload...)
+	return writeMessage(conn, n.Network, command, payload)
+}
 
-	sendData(address, request)
+func (n *Node) sendAddr(p *Peer) {
+	nodes := addr{n.KnownNodes()}
+	nodes.AddrList = append(nodes.AddrList, n.Address)
+	payload := encodePayload(&nodes)
+	n.sendData(p, "addr", payload)
 }
 
-func sendBlock(addr string, b *Block) {
-	data := block{nodeAddress, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("block"), payload...)
+func (n *Node) sendBlock(p *Peer, b *Block) {
+	data := block{n.Address, b.Serialize()}
+	payload := encodePayload(&data)
+	n.sendData(p, "block", payload)
+}
 
-	sendData(addr, request)
+func (n *Node) sendInv(p *Peer, kind string, items [][]byte) {
+	inventory := inv{n.Address, kind, items}
+	payload := encodePayload(&inventory)
+	n.sendData(p, "inv", payload)
 }
 
-func sendInv(address, kind string, items [][]byte) {
-	inventory := inv{nodeAddress, kind, items}
-	payload := gobEncode(inventory)
-	request := append(commandToBytes("inv"), payload...)
+func (n *Node) sendGetBlocks(p *Peer) {
+	payload := encodePayload(&getblocks{n.Address, n.bc.BlockLocator(), nil})
+	n.sendData(p, "getblocks", payload)
+}
 
-	sendData(address, request)
+func (n *Node) sendGetHeaders(p *Peer, locator [][]byte, stop []byte) {
+	payload := encodePayload(&getheaders{AddrFrom: n.Address, Locator: locator, StopHash: stop})
+	n.sendData(p, "getheaders", payload)
 }
 
-func sendGetBlocks(address string) {
-	payload := gobEncode(getblocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
+func (n *Node) sendHeaders(p *Peer, blocks []*Block) {
+	data := headers{n.Address, [][]byte{}}
+	for _, block := range blocks {
+		data.Headers = append(data.Headers, block.Serialize())
+	}
+	payload := encodePayload(&data)
+	n.sendData(p, "headers", payload)
+}
 
-	sendData(address, request)
+func (n *Node) sendProofs(p *Peer, txProofs []txProof) {
+	payload := encodePayload(&proofs{n.Address, txProofs})
+	n.sendData(p, "proofs", payload)
 }
 
-func sendGetData(address, kind string, id []byte) {
-	payload := gobEncode(getdata{nodeAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
+func (n *Node) sendMempool(p *Peer) {
+	payload := encodePayload(&mempoolInfo{n.Address, n.mempool.Info()})
+	n.sendData(p, "mempool", payload)
+}
 
-	sendData(address, request)
+func (n *Node) sendGetData(p *Peer, kind string, id []byte) {
+	payload := encodePayload(&getdata{n.Address, kind, id})
+	n.sendData(p, "getdata", payload)
 }
 
-func sendTx(addr string, tnx *Transaction) {
-	data := tx{nodeAddress, tnx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("tx"), payload...)
+func (n *Node) sendTx(p *Peer, tnx *Transaction) {
+	data := tx{n.Address, tnx.Serialize()}
+	payload := encodePayload(&data)
+	n.sendData(p, "tx", payload)
+}
 
-	sendData(addr, request)
+//submitTx send tx to addr over a connection of its own
+//Processes without server such as CLI and light client use it
+func (n *Node) submitTx(addr string, tnx *Transaction) {
+	data := tx{n.Address, tnx.Serialize()}
+	err := n.sendOnce(addr, "tx", encodePayload(&data))
+	if err != nil {
+		fmt.Printf("Cannot send tx to %s: %s\n", addr, err)
+	}
 }
 
-func sendVersion(addr string, bc *BlockChain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(verzion{
+//newVersion return version payload of this node
+//Processes without blockchain such as light client and CLI announce themselves as clients
+func (n *Node) newVersion() []byte {
+	version := verzion{
 		Version:    nodeVersion,
-		BestHeight: bestHeight,
-		AddrFrom:   nodeAddress,
-	})
+		BestHeight: -1,
+		AddrFrom:   n.Address,
+		Client:     n.bc == nil,
+	}
+	if n.bc != nil {
+		version.BestHeight = n.bc.GetBestHeight()
+	}
 
-	request := append(commandToBytes("version"), payload...)
-	sendData(addr, request)
+	return encodePayload(&version)
 }
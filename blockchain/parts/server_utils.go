@@ -1,25 +1,24 @@
 package parts
 
-import "fmt"
+import "time"
 
 const (
-	protocol      = "tcp"
-	nodeVersion   = 1
-	commandLength = 12
+	protocol         = "tcp"
+	nodeVersion      = 1
+	commandLength    = 12
+	maxHeadersPerMsg = 2000
+	maxInvPerMsg     = 500
+	requestTimeout   = 30 * time.Second
 )
 
-var nodeAddress string
-var miningAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
-var mempool = make(map[string]Transaction)
-
 //verzion version is already declared
 //verzion show information of node
+//Client is set by processes which do not accept connections. They are neither synced nor remembered as known node
 type verzion struct {
 	Version    int
 	BestHeight int
 	AddrFrom   string
+	Client     bool
 }
 
 type addr struct {
@@ -32,48 +31,68 @@ type inv struct {
 	Items    [][]byte
 }
 
+//getblocks ask hashes of blocks after the first hash of Locator in main chain of the peer
+//Hashes end at StopHash, and nil StopHash asks as many as the peer sends in one message
 type getblocks struct {
 	AddrFrom string
+	Locator  [][]byte
+	StopHash []byte
 }
 
-type getdata struct {
-	AddrFrom string
-	Type     string
-	ID       []byte
+//getheaders ask headers like getblocks
+//FromHeight is used instead of Locator when it is empty, as light clients of older version do
+type getheaders struct {
+	AddrFrom   string
+	FromHeight int
+	Locator    [][]byte
+	StopHash   []byte
 }
 
-type block struct {
+type headers struct {
 	AddrFrom string
-	Block    []byte
+	Headers  [][]byte
 }
 
-type tx struct {
-	AddFrom     string
-	Transaction []byte
+type getproofs struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
 }
 
-func commandToBytes(command string) []byte {
-	var bytes [commandLength]byte
+//txProof prove transaction is included in a block
+//Outputs are indexes of unspent outputs of the transaction
+type txProof struct {
+	Transaction []byte
+	BlockHash   []byte
+	Proof       MerkleProof
+	Outputs     []int
+}
 
-	for i, c := range command {
-		bytes[i] = byte(c)
-	}
+type proofs struct {
+	AddrFrom string
+	Proofs   []txProof
+}
 
-	return bytes[:]
+type getmempool struct {
+	AddrFrom string
 }
 
-func bytesToCommand(bytes []byte) string {
-	var command []byte
+type mempoolInfo struct {
+	AddrFrom string
+	Entries  []MempoolEntryInfo
+}
 
-	for _, b := range bytes {
-		if b != 0x0 {
-			command = append(command, b)
-		}
-	}
+type getdata struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
 
-	return fmt.Sprintf("%s", command)
+type block struct {
+	AddrFrom string
+	Block    []byte
 }
 
-func extractCommand(request []byte) []byte {
-	return request[:commandLength]
+type tx struct {
+	AddFrom     string
+	Transaction []byte
 }
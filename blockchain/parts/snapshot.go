@@ -0,0 +1,565 @@
+package parts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Snapshot file is magic, 4 bytes version, 8 bytes height, 32 bytes commitment and chunks
+//Chunks are hash of snapshot block, headers from genesis to snapshot block and UTXO entries
+//Number of entries is written as 8 bytes right before the entries
+const (
+	snapshotMagic       = "PBCU"
+	snapshotVersion     = 2
+	maxSnapshotEntryLen = 1024 * 1024
+	snapshotProgress    = 10000
+)
+
+//Keys of meta bucket for snapshot which is not validated yet
+const (
+	snapshotHashKey       = "snapshot"
+	snapshotCommitmentKey = "snapshotcommitment"
+)
+
+//ErrSnapshotMismatch means blocks up to snapshot block do not make UTXO set of snapshot
+var ErrSnapshotMismatch = errors.New("UTXO set of snapshot does not match blocks")
+
+//encodeUTXOEntry write unspent outputs of a transaction in fixed layout
+//Every output is 4 bytes index, 8 bytes value and length prefixed pubkey hash
+//It is kept apart from storage encoding because commitment must not change with it
+func encodeUTXOEntry(txID []byte, outs TxOutputs) []byte {
+	var buff bytes.Buffer
+	num := make([]byte, 8)
+
+	binary.BigEndian.PutUint32(num, uint32(len(txID)))
+	buff.Write(num[:4])
+	buff.Write(txID)
+
+	binary.BigEndian.PutUint32(num, uint32(len(outs.Outputs)))
+	buff.Write(num[:4])
+	for i, out := range outs.Outputs {
+		binary.BigEndian.PutUint32(num, uint32(outs.Index(i)))
+		buff.Write(num[:4])
+		binary.BigEndian.PutUint64(num, uint64(out.Value))
+		buff.Write(num)
+		binary.BigEndian.PutUint32(num, uint32(len(out.PubKeyHash)))
+		buff.Write(num[:4])
+		buff.Write(out.PubKeyHash)
+	}
+
+	return buff.Bytes()
+}
+
+//decodeUTXOEntry read entry written by encodeUTXOEntry
+func decodeUTXOEntry(data []byte) ([]byte, TxOutputs, error) {
+	var outs TxOutputs
+	errBroken := errors.New("Broken UTXO entry")
+	r := bytes.NewReader(data)
+
+	readBytes := func() ([]byte, error) {
+		var n uint32
+		err := binary.Read(r, binary.BigEndian, &n)
+		if err != nil || int(n) > r.Len() {
+			return nil, errBroken
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+
+	txID, err := readBytes()
+	if err != nil || len(txID) == 0 {
+		return nil, outs, errBroken
+	}
+
+	var count uint32
+	err = binary.Read(r, binary.BigEndian, &count)
+	if err != nil || count == 0 {
+		return nil, outs, errBroken
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var index uint32
+		var value uint64
+		err = binary.Read(r, binary.BigEndian, &index)
+		if err != nil {
+			return nil, outs, errBroken
+		}
+		err = binary.Read(r, binary.BigEndian, &value)
+		if err != nil {
+			return nil, outs, errBroken
+		}
+		pubKeyHash, err := readBytes()
+		if err != nil {
+			return nil, outs, errBroken
+		}
+		if len(outs.Indexes) > 0 && int(index) <= outs.Indexes[len(outs.Indexes)-1] {
+			return nil, outs, errBroken
+		}
+
+		outs.Outputs = append(outs.Outputs, TxOutput{int(value), pubKeyHash})
+		outs.Indexes = append(outs.Indexes, int(index))
+	}
+	if r.Len() != 0 {
+		return nil, outs, errBroken
+	}
+
+	return txID, outs, nil
+}
+
+//Commitment return SHA-256 of every UTXO entry in order of transaction ID
+//Nodes with the same UTXO set have the same commitment regardless of how they stored it
+func (u UTXOSet) Commitment() []byte {
+	hasher := sha256.New()
+
+	err := u.BlockChain.Db.View(func(tx StorageTx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := decodeOutputs(v)
+			if err != nil {
+				return err
+			}
+			hasher.Write(encodeUTXOEntry(k, outs))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return hasher.Sum(nil)
+}
+
+//replayChain build UTXO set of main chain up to height in memory by validating every block again
+//block return full block of height. Genesis block is trusted as the first block of the chain
+func replayChain(height int, block func(height int) (*Block, error)) (*BlockChain, error) {
+	genesis, err := block(0)
+	if err != nil {
+		return nil, err
+	}
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 || genesis.Bits != initialBits {
+		return nil, errors.New("First block is not genesis block")
+	}
+	err = CheckBlockSanity(genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := CreateBlockChainFromGenesis(genesis, NewMemoryStorage())
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	for h := 1; h <= height; h++ {
+		b, err := block(h)
+		if err != nil {
+			return nil, err
+		}
+
+		err = bc.AddBlock(b)
+		if err != nil {
+			return nil, fmt.Errorf("Block %x at height %d is invalid: %s", b.Hash, h, err)
+		}
+		if bytes.Compare(bc.Tip, b.Hash) != 0 {
+			return nil, fmt.Errorf("Block %x at height %d does not extend the chain", b.Hash, h)
+		}
+		if h%bootstrapProgress == 0 {
+			fmt.Printf("Replayed blocks up to height %d\n", h)
+		}
+	}
+
+	return bc, nil
+}
+
+//DumpUTXOSet write UTXO set at main chain block of height with headers up to the block
+//Negative height means the tip. For older height the chain is replayed in memory,
+//so blocks up to height must not be pruned. It returns commitment of the snapshot
+func (bc *BlockChain) DumpUTXOSet(w io.Writer, height int) ([]byte, error) {
+	bestHeight := bc.GetBestHeight()
+	if height < 0 {
+		height = bestHeight
+	}
+	if height > bestHeight {
+		return nil, fmt.Errorf("Height %d is above the tip", height)
+	}
+
+	//Other nodes cannot check headers of legacy blocks
+	if bc.legacyHeight >= 0 {
+		return nil, errors.New("Chain migrated from gob encoding cannot be dumped")
+	}
+
+	source := bc
+	if height != bestHeight {
+		replayed, err := replayChain(height, func(h int) (*Block, error) {
+			block, err := bc.GetBlockByHeight(h)
+			if err != nil {
+				return nil, err
+			}
+			if block.IsPruned() {
+				return nil, fmt.Errorf("Block at height %d is pruned", h)
+			}
+			return &block, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		source = replayed
+	}
+
+	hashes := bc.GetBlockHashRange(0, height)
+	commitment := UTXOSet{source}.Commitment()
+
+	header := make([]byte, 16)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[4:], snapshotVersion)
+	binary.BigEndian.PutUint64(header[8:], uint64(height))
+	_, err := w.Write(append(header, commitment...))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeChunk(w, hashes[height])
+	if err != nil {
+		return nil, err
+	}
+
+	for from := 0; from <= height; from += bootstrapProgress {
+		for _, block := range bc.GetBlocksByHeight(from, from+bootstrapProgress-1) {
+			if block.Height > height {
+				break
+			}
+			err = writeChunk(w, block.Header().Serialize())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = source.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+
+		count := 0
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			count++
+		}
+
+		num := make([]byte, 8)
+		binary.BigEndian.PutUint64(num, uint64(count))
+		_, err := w.Write(num)
+		if err != nil {
+			return err
+		}
+
+		c = b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := decodeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			err = writeChunk(w, encodeUTXOEntry(k, outs))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return commitment, nil
+}
+
+//LoadUTXOSnapshot start empty db from snapshot file
+//Headers are checked from genesis, and the snapshot is rejected when its entries do not make its commitment
+//or commitment is not expected one. Nil expected accepts commitment written in the file.
+//Blocks up to the snapshot block are stored as headers until ValidateSnapshot replays them
+func LoadUTXOSnapshot(r io.Reader, db Storage, expected []byte) (*BlockChain, error) {
+	header := make([]byte, 16+sha256.Size)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read snapshot header: %s", err)
+	}
+	if !bytes.Equal(header[:4], []byte(snapshotMagic)) {
+		return nil, errors.New("Not a snapshot file")
+	}
+	if version := binary.BigEndian.Uint32(header[4:]); version != snapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", version)
+	}
+	height := int(binary.BigEndian.Uint64(header[8:]))
+	commitment := header[16:]
+	if expected != nil && !bytes.Equal(commitment, expected) {
+		return nil, fmt.Errorf("Snapshot commitment %x is not expected %x", commitment, expected)
+	}
+
+	snapshotHash, err := readChunk(r, maxBootstrapBlockLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []*Block
+	lookup := func(hash []byte) *Block {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if bytes.Equal(headers[i].Hash, hash) {
+				return headers[i]
+			}
+		}
+		return nil
+	}
+	for h := 0; h <= height; h++ {
+		data, err := readChunk(r, maxBootstrapBlockLen)
+		if err != nil {
+			return nil, err
+		}
+		block, err := decodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+
+		err = CheckHeaderSanity(block)
+		if err != nil {
+			return nil, fmt.Errorf("Header at height %d is invalid: %s", h, err)
+		}
+		if block.Height != h {
+			return nil, fmt.Errorf("Header at height %d has height %d", h, block.Height)
+		}
+		if h == 0 {
+			if len(block.PrevBlockHash) != 0 || block.Bits != initialBits {
+				return nil, errors.New("Snapshot does not start with genesis header")
+			}
+		} else {
+			parent := headers[h-1]
+			if !bytes.Equal(block.PrevBlockHash, parent.Hash) {
+				return nil, fmt.Errorf("Header at height %d does not follow previous header", h)
+			}
+			err = checkBlockContext(lookup, block, parent)
+			if err != nil {
+				return nil, fmt.Errorf("Header at height %d is invalid: %s", h, err)
+			}
+		}
+		headers = append(headers, block.Header())
+	}
+	if !bytes.Equal(headers[height].Hash, snapshotHash) {
+		return nil, errors.New("Headers do not end with snapshot block")
+	}
+
+	num := make([]byte, 8)
+	_, err = io.ReadFull(r, num)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read number of entries: %s", err)
+	}
+	count := binary.BigEndian.Uint64(num)
+
+	hasher := sha256.New()
+	err = db.Update(func(tx StorageTx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err != nil {
+			return err
+		}
+		for _, block := range headers {
+			err = b.Put(block.Hash, block.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+		err = b.Put([]byte("l"), snapshotHash)
+		if err != nil {
+			return err
+		}
+
+		u, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+		var lastID []byte
+		for i := uint64(0); i < count; i++ {
+			data, err := readChunk(r, maxSnapshotEntryLen)
+			if err != nil {
+				return err
+			}
+			txID, outs, err := decodeUTXOEntry(data)
+			if err != nil {
+				return err
+			}
+			//Sorted entries make commitment independent of the writer
+			if bytes.Compare(txID, lastID) <= 0 {
+				return fmt.Errorf("UTXO entry %x is out of order", txID)
+			}
+			lastID = txID
+
+			hasher.Write(data)
+			err = u.Put(txID, outs.Serialize())
+			if err != nil {
+				return err
+			}
+			if (i+1)%snapshotProgress == 0 {
+				fmt.Printf("Loaded %d UTXO entries\n", i+1)
+			}
+		}
+		if !bytes.Equal(hasher.Sum(nil), commitment) {
+			return errors.New("UTXO entries do not match snapshot commitment")
+		}
+
+		err = setEncoding(tx)
+		if err != nil {
+			return err
+		}
+		meta := tx.Bucket([]byte(metaBucket))
+		err = meta.Put([]byte(prunedHeightKey), heightKey(height))
+		if err != nil {
+			return err
+		}
+		err = meta.Put([]byte(snapshotHashKey), snapshotHash)
+		if err != nil {
+			return err
+		}
+		err = meta.Put([]byte(snapshotCommitmentKey), commitment)
+		if err != nil {
+			return err
+		}
+
+		err = initChainWork(tx, snapshotHash)
+		if err != nil {
+			return err
+		}
+		return initHeightIndex(tx, snapshotHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBlockChainWithStorage(db), nil
+}
+
+//SnapshotHeight return height of snapshot block which is not validated yet
+//It is -1 when chain was not started from snapshot or the snapshot is validated
+func (bc *BlockChain) SnapshotHeight() int {
+	height := -1
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		meta := tx.Bucket([]byte(metaBucket))
+		if meta == nil {
+			return nil
+		}
+		hash := meta.Get([]byte(snapshotHashKey))
+		if hash == nil {
+			return nil
+		}
+
+		height = DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(hash)).Height
+		return nil
+	})
+	if err != nil {
+		return -1
+	}
+	return height
+}
+
+//ValidateSnapshot replay blocks up to snapshot block and compare UTXO set with commitment of snapshot
+//fetch return full block of hash and is called for every block stored as header.
+//ErrSnapshotMismatch is returned when snapshot was not made from the chain.
+//Replay only reads blockchain. The returned commit forgets the snapshot and, without pruning, keeps fetched blocks
+//and their undo data. Caller runs it where no block is added at the same time
+func (bc *BlockChain) ValidateSnapshot(fetch func(hash []byte) (*Block, error)) (func() error, error) {
+	height := bc.SnapshotHeight()
+	if height < 0 {
+		return func() error { return nil }, nil
+	}
+
+	var commitment []byte
+	err := bc.Db.View(func(tx StorageTx) error {
+		commitment = append([]byte{}, tx.Bucket([]byte(metaBucket)).Get([]byte(snapshotCommitmentKey))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	replayed, err := replayChain(height, func(h int) (*Block, error) {
+		stub, err := bc.GetBlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+		if !stub.IsPruned() {
+			return &stub, nil
+		}
+
+		block, err := fetch(stub.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(block.Hash, stub.Hash) {
+			return nil, fmt.Errorf("Fetched block %x is not block %x", block.Hash, stub.Hash)
+		}
+		return block, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(UTXOSet{replayed}.Commitment(), commitment) {
+		return nil, ErrSnapshotMismatch
+	}
+
+	var blocks []*Block
+	undo := make(map[string][]byte)
+	if bc.pruneDepth == 0 {
+		blocks = replayed.GetBlocksByHeight(0, height)
+		err = replayed.Db.View(func(tx StorageTx) error {
+			ub := tx.Bucket([]byte(undoBucket))
+			for _, block := range blocks {
+				if data := ub.Get(block.Hash); data != nil {
+					undo[string(block.Hash)] = append([]byte{}, data...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	commit := func() error {
+		return bc.Db.Update(func(tx StorageTx) error {
+			meta := tx.Bucket([]byte(metaBucket))
+			err := meta.Delete([]byte(snapshotHashKey))
+			if err != nil {
+				return err
+			}
+			err = meta.Delete([]byte(snapshotCommitmentKey))
+			if err != nil {
+				return err
+			}
+			if bc.pruneDepth != 0 {
+				return nil
+			}
+
+			b := tx.Bucket([]byte(blocksBucket))
+			ub, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+			if err != nil {
+				return err
+			}
+			for _, block := range blocks {
+				err = b.Put(block.Hash, block.Serialize())
+				if err != nil {
+					return err
+				}
+				if data, ok := undo[string(block.Hash)]; ok {
+					err = ub.Put(block.Hash, data)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return meta.Delete([]byte(prunedHeightKey))
+		})
+	}
+
+	return commit, nil
+}
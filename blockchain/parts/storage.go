@@ -0,0 +1,47 @@
+package parts
+
+import "errors"
+
+//Errors of Storage
+var (
+	ErrBucketNotFound   = errors.New("Bucket is not found")
+	ErrBucketExists     = errors.New("Bucket already exists")
+	ErrTxNotWritable    = errors.New("Storage transaction is read only")
+	ErrStorageKeyEmpty  = errors.New("Key is empty")
+	ErrStorageNotActive = errors.New("Storage is closed")
+)
+
+//Storage is key value store which keeps blockchain in named buckets
+//View runs fn with read only transaction
+//Update runs fn atomically. Every change is discarded when fn returns error
+type Storage interface {
+	View(fn func(StorageTx) error) error
+	Update(fn func(StorageTx) error) error
+	Close() error
+}
+
+//StorageTx is a transaction of Storage
+//Bucket returns nil when the bucket does not exist
+type StorageTx interface {
+	Bucket(name []byte) StorageBucket
+	CreateBucket(name []byte) (StorageBucket, error)
+	CreateBucketIfNotExists(name []byte) (StorageBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+//StorageBucket is a set of key value pairs
+//Bytes returned by Get and cursor are valid only in the transaction
+type StorageBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() StorageCursor
+}
+
+//StorageCursor iterate bucket in ascending byte order of keys
+//key is nil when there is no more pair
+type StorageCursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
@@ -0,0 +1,101 @@
+package parts
+
+import "github.com/boltdb/bolt"
+
+//boltStorage keep buckets in a bolt db file
+type boltStorage struct {
+	db *bolt.DB
+}
+
+//OpenBoltStorage open bolt db file at path. The file is created when it does not exist
+func OpenBoltStorage(path string) (Storage, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStorage{db}, nil
+}
+
+func (s *boltStorage) View(fn func(StorageTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStorage) Update(fn func(StorageTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+//Bucket must return untyped nil, not boltBucket with nil *bolt.Bucket
+func (t boltTx) Bucket(name []byte) StorageBucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name []byte) (StorageBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (StorageBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	return boltError(t.tx.DeleteBucket(name))
+}
+
+//boltBucket use methods of bolt bucket except Cursor
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (b boltBucket) Put(key, value []byte) error {
+	return boltError(b.Bucket.Put(key, value))
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	return boltError(b.Bucket.Delete(key))
+}
+
+func (b boltBucket) Cursor() StorageCursor {
+	return b.Bucket.Cursor()
+}
+
+//boltError translate bolt errors to errors of Storage
+func boltError(err error) error {
+	switch err {
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrBucketExists:
+		return ErrBucketExists
+	case bolt.ErrTxNotWritable:
+		return ErrTxNotWritable
+	case bolt.ErrKeyRequired:
+		return ErrStorageKeyEmpty
+	case bolt.ErrDatabaseNotOpen:
+		return ErrStorageNotActive
+	}
+	return err
+}
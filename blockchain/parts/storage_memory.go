@@ -0,0 +1,245 @@
+package parts
+
+import (
+	"sort"
+	"sync"
+)
+
+//memoryStorage keep buckets in maps without touching disk
+//Update holds the lock until it ends, and undoes its writes in reverse order when fn fails
+type memoryStorage struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+}
+
+//NewMemoryStorage make empty in-memory storage
+//Everything is lost when the process ends
+func NewMemoryStorage() Storage {
+	return &memoryStorage{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryStorage) View(fn func(StorageTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.buckets == nil {
+		return ErrStorageNotActive
+	}
+	return fn(&memoryTx{storage: s})
+}
+
+func (s *memoryStorage) Update(fn func(StorageTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets == nil {
+		return ErrStorageNotActive
+	}
+
+	tx := &memoryTx{storage: s, writable: true}
+	err := fn(tx)
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+	return err
+}
+
+func (s *memoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buckets = nil
+	return nil
+}
+
+//memoryBucket keep sorted keys until a key is added or removed
+//Concurrent views sort keys lazily, so sorted has its own lock
+type memoryBucket struct {
+	data map[string][]byte
+
+	sortMu sync.Mutex
+	sorted []string
+}
+
+func newMemoryBucket() *memoryBucket {
+	return &memoryBucket{data: make(map[string][]byte)}
+}
+
+//keys return sorted keys
+//The slice is replaced, not modified, by later writes so cursors can keep it
+func (b *memoryBucket) keys() []string {
+	b.sortMu.Lock()
+	defer b.sortMu.Unlock()
+
+	if b.sorted == nil {
+		b.sorted = make([]string, 0, len(b.data))
+		for k := range b.data {
+			b.sorted = append(b.sorted, k)
+		}
+		sort.Strings(b.sorted)
+	}
+	return b.sorted
+}
+
+//set write value or delete key when value is nil
+func (b *memoryBucket) set(k string, value []byte) {
+	_, existed := b.data[k]
+	if value == nil {
+		delete(b.data, k)
+	} else {
+		b.data[k] = value
+	}
+
+	if existed != (value != nil) {
+		b.sortMu.Lock()
+		b.sorted = nil
+		b.sortMu.Unlock()
+	}
+}
+
+type memoryTx struct {
+	storage  *memoryStorage
+	writable bool
+	undo     []func()
+}
+
+func (t *memoryTx) Bucket(name []byte) StorageBucket {
+	b := t.storage.buckets[string(name)]
+	if b == nil {
+		return nil
+	}
+	return &memoryBucketTx{t, b}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (StorageBucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if len(name) == 0 {
+		return nil, ErrStorageKeyEmpty
+	}
+
+	k := string(name)
+	if t.storage.buckets[k] != nil {
+		return nil, ErrBucketExists
+	}
+
+	b := newMemoryBucket()
+	t.storage.buckets[k] = b
+	t.undo = append(t.undo, func() {
+		delete(t.storage.buckets, k)
+	})
+
+	return &memoryBucketTx{t, b}, nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (StorageBucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+
+	k := string(name)
+	b := t.storage.buckets[k]
+	if b == nil {
+		return ErrBucketNotFound
+	}
+
+	delete(t.storage.buckets, k)
+	t.undo = append(t.undo, func() {
+		t.storage.buckets[k] = b
+	})
+
+	return nil
+}
+
+//memoryBucketTx is a bucket seen from a transaction
+type memoryBucketTx struct {
+	tx     *memoryTx
+	bucket *memoryBucket
+}
+
+func (b *memoryBucketTx) Get(key []byte) []byte {
+	return b.bucket.data[string(key)]
+}
+
+func (b *memoryBucketTx) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return b.write(key, append([]byte{}, value...))
+}
+
+func (b *memoryBucketTx) Delete(key []byte) error {
+	return b.write(key, nil)
+}
+
+func (b *memoryBucketTx) write(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	if len(key) == 0 {
+		return ErrStorageKeyEmpty
+	}
+
+	k := string(key)
+	bucket := b.bucket
+	old, existed := bucket.data[k]
+	if !existed {
+		old = nil
+	}
+
+	bucket.set(k, value)
+	b.tx.undo = append(b.tx.undo, func() {
+		bucket.set(k, old)
+	})
+
+	return nil
+}
+
+func (b *memoryBucketTx) Cursor() StorageCursor {
+	return &memoryCursor{bucket: b.bucket}
+}
+
+//memoryCursor walk keys sorted when it is positioned
+//Keys deleted after that are skipped
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	pos    int
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	c.keys = c.bucket.keys()
+	c.pos = 0
+	return c.current()
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	c.pos++
+	return c.current()
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	c.keys = c.bucket.keys()
+	c.pos = sort.SearchStrings(c.keys, string(seek))
+	return c.current()
+}
+
+func (c *memoryCursor) current() ([]byte, []byte) {
+	for ; c.pos < len(c.keys); c.pos++ {
+		k := c.keys[c.pos]
+		if v, ok := c.bucket.data[k]; ok {
+			return []byte(k), v
+		}
+	}
+	return nil, nil
+}
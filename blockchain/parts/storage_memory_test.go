@@ -0,0 +1,131 @@
+package parts
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func putKeys(t *testing.T, s Storage, bucket string, keys ...string) {
+	err := s.Update(func(tx StorageTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = b.Put([]byte(k), []byte("v"+k))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cursorKeys(s Storage, bucket string) ([]string, error) {
+	var keys []string
+
+	err := s.View(func(tx StorageTx) error {
+		c := tx.Bucket([]byte(bucket)).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+
+	return keys, err
+}
+
+func TestMemoryStorageCursorIsSorted(t *testing.T) {
+	s := NewMemoryStorage()
+	putKeys(t, s, "b", "c", "a", "b")
+
+	keys, err := cursorKeys(s, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[a b c]" {
+		t.Fatalf("Cursor returned %v", keys)
+	}
+
+	err = s.View(func(tx StorageTx) error {
+		k, v := tx.Bucket([]byte("b")).Cursor().Seek([]byte("ab"))
+		if string(k) != "b" || string(v) != "vb" {
+			return fmt.Errorf("Seek returned %s=%s", k, v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryStorageUndoFailedUpdate(t *testing.T) {
+	s := NewMemoryStorage()
+	putKeys(t, s, "b", "a")
+
+	failed := errors.New("failed")
+	err := s.Update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte("b"))
+		b.Put([]byte("a"), []byte("changed"))
+		b.Put([]byte("new"), []byte("v"))
+		tx.CreateBucket([]byte("other"))
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("Update returned %v", err)
+	}
+
+	err = s.View(func(tx StorageTx) error {
+		if tx.Bucket([]byte("other")) != nil {
+			return errors.New("Created bucket is kept")
+		}
+		b := tx.Bucket([]byte("b"))
+		if string(b.Get([]byte("a"))) != "va" || b.Get([]byte("new")) != nil {
+			return errors.New("Writes are kept")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+//Views sort keys of a bucket changed by the last update at the same time
+func TestMemoryStorageConcurrentViews(t *testing.T) {
+	s := NewMemoryStorage()
+
+	for round := 0; round < 20; round++ {
+		putKeys(t, s, "b", fmt.Sprint(round))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				keys, err := cursorKeys(s, "b")
+				if err != nil || len(keys) != round+1 {
+					t.Errorf("View returned %d keys, %v", len(keys), err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestMemoryStorageClose(t *testing.T) {
+	s := NewMemoryStorage()
+	s.Close()
+
+	err := s.View(func(tx StorageTx) error {
+		return nil
+	})
+	if err != ErrStorageNotActive {
+		t.Fatalf("View of closed storage returned %v", err)
+	}
+}
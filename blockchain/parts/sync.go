@@ -0,0 +1,454 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	maxBlocksInFlight   = 16
+	blockDownloadWindow = 1024
+	blockTimeout        = 20 * time.Second
+	syncTickInterval    = time.Second
+)
+
+//blockRequest is getdata of a block waiting for reply
+type blockRequest struct {
+	peer *Peer
+	sent time.Time
+}
+
+//syncManager download headers first and then blocks of the header chain from every peer
+//headers follow a block of blockchain in order of height. They are checked as a chain before any block is requested
+//Blocks arrive in any order and are connected in order of height
+//It is touched only by message loop of node
+type syncManager struct {
+	node *Node
+
+	headers []*Block
+	index   map[string]*Block
+	//work is cumulative work of every header of header chain
+	work     map[string]*big.Int
+	blocks   map[string]*Block
+	senders  map[string]*Peer
+	inFlight map[string]*blockRequest
+	//timedOut is the peer which did not send the block, or sent a wrong one, last time
+	timedOut    map[string]*Peer
+	peerHeights map[*Peer]int
+
+	headersPeer *Peer
+	headersSent time.Time
+}
+
+func newSyncManager(node *Node) *syncManager {
+	return &syncManager{
+		node:        node,
+		index:       make(map[string]*Block),
+		work:        make(map[string]*big.Int),
+		blocks:      make(map[string]*Block),
+		senders:     make(map[string]*Peer),
+		inFlight:    make(map[string]*blockRequest),
+		timedOut:    make(map[string]*Peer),
+		peerHeights: make(map[*Peer]int),
+	}
+}
+
+//setPeerHeight remember the highest block peer announced
+func (s *syncManager) setPeerHeight(p *Peer, height int) {
+	if current, ok := s.peerHeights[p]; !ok || height > current {
+		s.peerHeights[p] = height
+	}
+}
+
+//bestHeight return height of the last header, or of blockchain when there is no header to download
+func (s *syncManager) bestHeight() int {
+	if len(s.headers) > 0 {
+		return s.headers[len(s.headers)-1].Height
+	}
+	return s.node.bc.GetBestHeight()
+}
+
+//lookup find header in header chain or block in blockchain
+func (s *syncManager) lookup(hash []byte) *Block {
+	if header, ok := s.index[hex.EncodeToString(hash)]; ok {
+		return header
+	}
+
+	block, err := s.node.bc.GetBlock(hash)
+	if err != nil {
+		return nil
+	}
+	return &block
+}
+
+//tipHash return hash of the last header, or of blockchain tip when there is no header
+func (s *syncManager) tipHash() []byte {
+	if len(s.headers) > 0 {
+		return s.headers[len(s.headers)-1].Hash
+	}
+	return s.node.bc.Tip
+}
+
+//chainWork return cumulative work of header or stored block of hash
+func (s *syncManager) chainWork(hash []byte) *big.Int {
+	if work, ok := s.work[hex.EncodeToString(hash)]; ok {
+		return work
+	}
+	return s.node.bc.chainWork(hash)
+}
+
+//locator return locator of header chain continued by main chain below it
+func (s *syncManager) locator() [][]byte {
+	if len(s.headers) == 0 {
+		return s.node.bc.BlockLocator()
+	}
+
+	first := s.headers[0].Height
+	return blockLocator(s.bestHeight(), func(height int) []byte {
+		if height >= first {
+			return s.headers[height-first].Hash
+		}
+		hash, _ := s.node.bc.GetBlockHash(height)
+		return hash
+	})
+}
+
+//requestHeaders ask headers after our header chain to peer
+//Only one request is sent at a time unless the previous one timed out
+func (s *syncManager) requestHeaders(p *Peer) {
+	if s.headersPeer != nil && time.Since(s.headersSent) < blockTimeout {
+		return
+	}
+
+	s.headersPeer = p
+	s.headersSent = time.Now()
+	s.node.sendGetHeaders(p, s.locator(), nil)
+}
+
+//onHeaders extend header chain and download blocks of it
+//Full batch means the peer has more headers, so the next batch is requested
+func (s *syncManager) onHeaders(from *Peer, headers []*Block) error {
+	if from == s.headersPeer {
+		s.headersPeer = nil
+	}
+
+	best := s.bestHeight()
+	err := s.addHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		s.setPeerHeight(from, headers[len(headers)-1].Height)
+	}
+	//Peer which has nothing new is not asked again for the height it announced
+	if s.bestHeight() == best && len(headers) < maxHeadersPerMsg {
+		s.peerHeights[from] = best
+	}
+	if len(headers) >= maxHeadersPerMsg {
+		s.requestHeaders(from)
+	}
+	s.requestBlocks()
+
+	return nil
+}
+
+//addHeaders check every header against its parent and append it to header chain
+//Header whose parent is not the last header starts a branch from the parent.
+//The branch replaces headers after the parent only when it has more cumulative work than header chain
+func (s *syncManager) addHeaders(headers []*Block) error {
+	//branch is checked headers following fork which are not in header chain yet, and works are their cumulative work
+	var fork *Block
+	var branch []*Block
+	var works []*big.Int
+	lookup := func(hash []byte) *Block {
+		for _, header := range branch {
+			if bytes.Equal(header.Hash, hash) {
+				return header
+			}
+		}
+		return s.lookup(hash)
+	}
+
+	for _, header := range headers {
+		if lookup(header.Hash) != nil {
+			continue
+		}
+
+		parent := lookup(header.PrevBlockHash)
+		if parent == nil {
+			return fmt.Errorf("Header %x does not connect to known blocks", header.Hash)
+		}
+
+		err := CheckHeaderSanity(header)
+		if err == nil {
+			err = checkBlockContext(lookup, header, parent)
+		}
+		if err != nil {
+			return err
+		}
+
+		//Header continues branch from its parent, or starts a branch from header chain or blockchain
+		i := len(branch) - 1
+		for i >= 0 && !bytes.Equal(branch[i].Hash, parent.Hash) {
+			i--
+		}
+		var work *big.Int
+		if i >= 0 {
+			work = works[i]
+		} else {
+			fork, work = parent, s.chainWork(parent.Hash)
+		}
+		branch = append(branch[:i+1:i+1], header)
+		works = append(works[:i+1:i+1], new(big.Int).Add(work, NewProofOfWork(header).Work()))
+
+		if !bytes.Equal(fork.Hash, s.tipHash()) && works[i+1].Cmp(s.chainWork(s.tipHash())) <= 0 {
+			continue
+		}
+
+		s.truncate(fork.Hash)
+		for j, h := range branch {
+			key := hex.EncodeToString(h.Hash)
+			s.headers = append(s.headers, h)
+			s.index[key] = h
+			s.work[key] = works[j]
+		}
+		branch, works = nil, nil
+	}
+
+	if len(branch) > 0 {
+		fmt.Printf("Header branch from height %d does not have more work than header chain\n", fork.Height+1)
+	}
+	return nil
+}
+
+//truncate drop headers after hash. Every header is dropped when hash is not in header chain
+func (s *syncManager) truncate(hash []byte) {
+	keep := 0
+	if parent, ok := s.index[hex.EncodeToString(hash)]; ok {
+		keep = parent.Height - s.headers[0].Height + 1
+	}
+	if keep == len(s.headers) {
+		return
+	}
+
+	fmt.Printf("Header chain forks at height %d\n", s.headers[keep].Height)
+	for _, header := range s.headers[keep:] {
+		key := hex.EncodeToString(header.Hash)
+		delete(s.index, key)
+		delete(s.work, key)
+		delete(s.blocks, key)
+		delete(s.senders, key)
+		delete(s.inFlight, key)
+		delete(s.timedOut, key)
+	}
+	s.headers = s.headers[:keep]
+}
+
+//requestBlocks send getdata for blocks in download window which are neither received nor requested
+//Each block is asked to the peer with the fewest requests among peers which have it
+func (s *syncManager) requestBlocks() {
+	load := make(map[*Peer]int)
+	for peer := range s.peerHeights {
+		if !peer.closed() {
+			load[peer] = 0
+		}
+	}
+	for _, req := range s.inFlight {
+		if _, ok := load[req.peer]; ok {
+			load[req.peer]++
+		}
+	}
+
+	for i, header := range s.headers {
+		if i >= blockDownloadWindow {
+			break
+		}
+
+		key := hex.EncodeToString(header.Hash)
+		if s.blocks[key] != nil || s.inFlight[key] != nil {
+			continue
+		}
+
+		peer := s.choosePeer(load, header.Height, s.timedOut[key])
+		if peer == nil {
+			break
+		}
+
+		load[peer]++
+		s.inFlight[key] = &blockRequest{peer, time.Now()}
+		s.node.sendGetData(peer, "block", header.Hash)
+	}
+}
+
+//choosePeer return least loaded peer which has block at height
+//Peer which timed out on the block is chosen only when no other peer can serve it
+func (s *syncManager) choosePeer(load map[*Peer]int, height int, timedOut *Peer) *Peer {
+	var peers []*Peer
+	for peer := range load {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].String() < peers[j].String()
+	})
+
+	var best *Peer
+	for _, peer := range peers {
+		if s.peerHeights[peer] < height || load[peer] >= maxBlocksInFlight {
+			continue
+		}
+		if best == nil || (best == timedOut && peer != timedOut) ||
+			(peer != timedOut && load[peer] < load[best]) {
+			best = peer
+		}
+	}
+
+	return best
+}
+
+//onBlock keep block of header chain and connect every block whose parent is connected
+//Block which does not match its header is asked to another peer. It returns false when the block is not in header chain
+func (s *syncManager) onBlock(from *Peer, block *Block) bool {
+	key := hex.EncodeToString(block.Hash)
+	header, ok := s.index[key]
+	if !ok {
+		return false
+	}
+
+	delete(s.inFlight, key)
+	if !bodyMatches(header, block) {
+		s.timedOut[key] = from
+		s.penalize(from, fmt.Sprintf("block %x does not match its header", block.Hash))
+		s.requestBlocks()
+		return true
+	}
+
+	delete(s.timedOut, key)
+	s.blocks[key] = block
+	s.senders[key] = from
+
+	s.connectBlocks()
+	s.requestBlocks()
+
+	return true
+}
+
+//bodyMatches check block has the header and transactions of the header
+//Peer can send any transactions with a valid header, so this is checked before the block is kept
+func bodyMatches(header, block *Block) bool {
+	return len(block.Transactions) > 0 &&
+		bytes.Equal(block.BlockHeader.Serialize(), header.BlockHeader.Serialize()) &&
+		bytes.Equal(block.HashTransactions(), header.MerkleRoot)
+}
+
+//headerRuleError report whether err breaks a rule of header, which no body of the block can satisfy
+func headerRuleError(err error) bool {
+	ruleErr, ok := err.(RuleError)
+	if !ok {
+		return false
+	}
+
+	switch ruleErr.Code {
+	case ErrBadBlockHash, ErrHighHash, ErrBadDifficulty, ErrTimeTooNew, ErrTimeTooOld,
+		ErrUnknownParent, ErrBadHeight, ErrBadVersion:
+		return true
+	}
+	return false
+}
+
+//connectBlocks add received blocks from the start of header chain
+//Block breaking a header rule drops the rest of header chain because its descendants cannot be valid.
+//Block rejected for its transactions is asked to another peer, and the sender is disconnected
+func (s *syncManager) connectBlocks() {
+	connected := false
+
+	for len(s.headers) > 0 {
+		header := s.headers[0]
+		key := hex.EncodeToString(header.Hash)
+
+		block, ok := s.blocks[key]
+		if _, err := s.node.bc.GetBlock(header.Hash); err == nil {
+			//Block arrived without request, for example from a miner
+		} else if !ok {
+			break
+		} else if err := s.node.bc.AddBlock(block); err != nil {
+			fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+			if headerRuleError(err) {
+				s.truncate(header.PrevBlockHash)
+				return
+			}
+
+			s.timedOut[key] = s.senders[key]
+			s.penalize(s.senders[key], fmt.Sprintf("block %x is invalid", block.Hash))
+			delete(s.blocks, key)
+			delete(s.senders, key)
+			break
+		} else {
+			fmt.Printf("Added block %x\n", block.Hash)
+			connected = true
+		}
+
+		s.headers = s.headers[1:]
+		delete(s.index, key)
+		delete(s.work, key)
+		delete(s.blocks, key)
+		delete(s.senders, key)
+	}
+
+	if connected && len(s.headers) == 0 {
+		fmt.Printf("Synced up to height %d\n", s.node.bc.GetBestHeight())
+	}
+}
+
+//penalize disconnect peer which sent invalid data, so blocks are asked to other peers
+func (s *syncManager) penalize(p *Peer, reason string) {
+	fmt.Printf("Disconnecting %s: %s\n", p, reason)
+	p.Close()
+	s.forgetPeer(p)
+}
+
+//forgetPeer drop height and requests of disconnected peer
+func (s *syncManager) forgetPeer(p *Peer) {
+	delete(s.peerHeights, p)
+	for key, req := range s.inFlight {
+		if req.peer == p {
+			delete(s.inFlight, key)
+		}
+	}
+	if s.headersPeer == p {
+		s.headersPeer = nil
+	}
+}
+
+//tick re-request timed out headers and blocks, and forget disconnected peers
+//It also starts header download when a peer announced more blocks than we have
+func (s *syncManager) tick() {
+	for peer := range s.peerHeights {
+		if peer.closed() {
+			s.forgetPeer(peer)
+		}
+	}
+
+	now := time.Now()
+	for key, req := range s.inFlight {
+		if now.Sub(req.sent) > blockTimeout {
+			fmt.Printf("Block %s is not received from %s in time\n", key, req.peer)
+			s.timedOut[key] = req.peer
+			delete(s.inFlight, key)
+		}
+	}
+
+	best := s.bestHeight()
+	for peer, height := range s.peerHeights {
+		if height > best && !peer.closed() {
+			s.requestHeaders(peer)
+			break
+		}
+	}
+
+	s.requestBlocks()
+}
@@ -0,0 +1,133 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+//testSyncManager return sync manager of bc with connected peers of names
+//Peers are not started, so messages sent to them stay in their queue
+func testSyncManager(bc *BlockChain, names ...string) (*syncManager, []*Peer) {
+	n := NewNode("0", "", "")
+	n.bc = bc
+	n.sync = newSyncManager(n)
+	n.peers = NewPeerManager(n)
+
+	var peers []*Peer
+	for _, name := range names {
+		conn, _ := net.Pipe()
+		peer := newPeer(n.peers, conn, name, false)
+		n.peers.peers[name] = peer
+		n.peers.all[peer] = true
+		peers = append(peers, peer)
+	}
+
+	return n.sync, peers
+}
+
+func TestSyncRequestsAlteredBlockFromOtherPeer(t *testing.T) {
+	source, others := testChains(t, 1, 1)
+	s, peers := testSyncManager(others[0], "a", "b")
+	good, bad := peers[0], peers[1]
+
+	headers := source.GetHeaders(1, maxHeadersPerMsg)
+	s.setPeerHeight(good, 1)
+	s.setPeerHeight(bad, 1)
+	err := s.onHeaders(bad, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := source.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hex.EncodeToString(block.Hash)
+	s.inFlight[key].peer = bad
+
+	//Valid header with transactions of other block
+	altered := block
+	altered.Transactions = []*Transaction{NewCoinbaseTx("altered", "", 1, 0)}
+	s.onBlock(bad, &altered)
+
+	if !bad.closed() {
+		t.Fatal("Peer sending altered block is kept")
+	}
+	if len(s.headers) != 1 {
+		t.Fatalf("Header chain has %d headers after altered block", len(s.headers))
+	}
+	if req := s.inFlight[key]; req == nil || req.peer != good {
+		t.Fatal("Block is not requested from other peer")
+	}
+	if _, ok := s.peerHeights[bad]; ok {
+		t.Fatal("Height of disconnected peer is kept")
+	}
+
+	s.onBlock(good, &block)
+	if others[0].GetBestHeight() != 1 || len(s.headers) != 0 {
+		t.Fatalf("Block from other peer is not connected, height %d", others[0].GetBestHeight())
+	}
+}
+
+func TestSyncForgetsDisconnectedPeers(t *testing.T) {
+	_, others := testChains(t, 0, 1)
+	s, peers := testSyncManager(others[0], "a")
+
+	s.setPeerHeight(peers[0], 10)
+	peers[0].Close()
+	s.tick()
+
+	if len(s.peerHeights) != 0 {
+		t.Fatal("Height of disconnected peer is kept")
+	}
+}
+
+//branchHeaders mine count headers following parent
+func branchHeaders(parent *Block, count int) []*Block {
+	address := string(NewWallet().GetAddress())
+
+	var headers []*Block
+	for i := 0; i < count; i++ {
+		block := NewBlock([]*Transaction{NewCoinbaseTx(address, "", parent.Height+1, 0)}, parent.Hash, parent.Height+1, initialBits)
+		headers = append(headers, block.Header())
+		parent = block
+	}
+	return headers
+}
+
+//Branch from a stored block replaces header chain only when it has more work
+func TestSyncKeepsHeaderChainWithMoreWork(t *testing.T) {
+	source, others := testChains(t, 3, 1)
+	s, _ := testSyncManager(others[0])
+	genesis, err := others[0].GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.addHeaders(source.GetHeaders(1, maxHeadersPerMsg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.addHeaders(branchHeaders(&genesis, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.headers) != 3 || !bytes.Equal(s.tipHash(), source.Tip) {
+		t.Fatalf("Header chain has %d headers after branch of less work, expected 3", len(s.headers))
+	}
+
+	branch := branchHeaders(&genesis, 4)
+	err = s.addHeaders(branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.headers) != 4 || !bytes.Equal(s.tipHash(), branch[3].Hash) {
+		t.Fatalf("Header chain has %d headers after branch of more work, expected 4", len(s.headers))
+	}
+	if _, ok := s.work[hex.EncodeToString(source.Tip)]; ok {
+		t.Fatal("Work of dropped header is kept")
+	}
+}
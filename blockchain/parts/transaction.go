@@ -6,7 +6,6 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -14,8 +13,6 @@ import (
 	"strings"
 )
 
-const subsidy = 10
-
 //Transaction includes ID, Transaction input and output
 type Transaction struct {
 	ID   []byte
@@ -23,17 +20,9 @@ type Transaction struct {
 	Vout []TxOutput
 }
 
-//Serialize serialize transaction
+//Serialize serialize transaction in canonical encoding
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return encoded.Bytes()
+	return encodeTransaction(&tx)
 }
 
 // Hash generate sha256 using tx without ID
@@ -50,18 +39,21 @@ func (tx *Transaction) Hash() []byte {
 
 //SetID literally set transaction ID
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
+	tx.ID = tx.Hash()
+}
 
-	enc := gob.NewEncoder(&encoded)
-	//receiver of Encode function is pointer
-	//Therefore when wnc is changed, encoded will be changed too
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+//HasValidID check ID is hash of transaction without signatures
+//ID is set before inputs are signed
+func (tx *Transaction) HasValidID() bool {
+	txCopy := *tx
+	txCopy.Vin = nil
+
+	for _, vin := range tx.Vin {
+		vin.Signature = nil
+		txCopy.Vin = append(txCopy.Vin, vin)
 	}
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+
+	return bytes.Compare(txCopy.Hash(), tx.ID) == 0
 }
 
 //String set information of tx to sting
@@ -89,9 +81,17 @@ func (tx Transaction) String() string {
 }
 
 //NewCoinbaseTx mint coinbase transaction of miner
-func NewCoinbaseTx(to, data string) *Transaction {
+//Miner claims subsidy of block at height and fees of transactions in the block
+func NewCoinbaseTx(to, data string, height, fees int) *Transaction {
+	//Random data keeps coinbase ID unique when same address is rewarded again
 	if data == "" {
-		data = fmt.Sprintf("Reward to '%s'", to)
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		data = fmt.Sprintf("Reward to '%s' %x", to, randData)
 	}
 
 	txin := TxInput{
@@ -101,7 +101,7 @@ func NewCoinbaseTx(to, data string) *Transaction {
 		Signature: nil,
 		PubKey:    []byte(data),
 	}
-	txout := NewTxOutput(subsidy, to)
+	txout := NewTxOutput(Emission.Subsidy(height)+fees, to)
 	tx := Transaction{
 		ID:   nil,
 		Vin:  []TxInput{txin},
@@ -118,19 +118,29 @@ func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
-
+//NewUTXOTransaction make transaction which sends amount from wallet to address and sign it
+//fee is left unspent by outputs so miner can claim it
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, UTXOSet *UTXOSet) *Transaction {
 	pubKeyHash := HashPubKey(wallet.PublicKey)
 
 	//accumulated, validaoutput
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("ERROR : Not enough funds")
 	}
 
+	tx := newTransaction(wallet, to, amount, fee, acc, validOutputs)
+	UTXOSet.BlockChain.SignTransaction(tx, wallet.PrivateKey)
+
+	return tx
+}
+
+//newTransaction make unsigned transaction which spends validOutputs worth acc
+func newTransaction(wallet *Wallet, to string, amount, fee, acc int, validOutputs map[string][]int) *Transaction {
+	var inputs []TxInput
+	var outputs []TxOutput
+
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 
@@ -151,9 +161,9 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 
 	from := fmt.Sprintf("%s", wallet.GetAddress())
 	outputs = append(outputs, *NewTxOutput(amount, to))
-	if acc > amount {
+	if acc > amount+fee {
 		//Return change to sender
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
+		outputs = append(outputs, *NewTxOutput(acc-amount-fee, from))
 	}
 
 	tx := Transaction{
@@ -162,7 +172,6 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, UTXOSet *UTXOSet)
 		Vout: outputs,
 	}
 	tx.ID = tx.Hash()
-	UTXOSet.BlockChain.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
@@ -267,10 +276,7 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 }
 
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := decodeTransaction(data)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -2,7 +2,6 @@ package parts
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
 )
 
@@ -38,28 +37,61 @@ func NewTxOutput(value int, address string) *TxOutput {
 	return txo
 }
 
+//TxOutputs is unspent outputs of a transaction
+//Indexes are positions of Outputs in the transaction
 type TxOutputs struct {
 	Outputs []TxOutput
+	Indexes []int
 }
 
-func (outs TxOutputs) Serialize() []byte {
-	var buff bytes.Buffer
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+//Index return position of i-th output in the transaction
+//Entries written without Indexes keep outputs at their original position
+func (outs TxOutputs) Index(i int) int {
+	if len(outs.Indexes) != len(outs.Outputs) {
+		return i
+	}
+	return outs.Indexes[i]
+}
+
+//Add put out at its position in the transaction
+func (outs *TxOutputs) Add(index int, out TxOutput) {
+	if len(outs.Indexes) != len(outs.Outputs) {
+		outs.Indexes = nil
+		for i := range outs.Outputs {
+			outs.Indexes = append(outs.Indexes, i)
+		}
+	}
+
+	pos := len(outs.Indexes)
+	for i, idx := range outs.Indexes {
+		if idx > index {
+			pos = i
+			break
+		}
 	}
 
-	return buff.Bytes()
+	outs.Outputs = append(outs.Outputs, TxOutput{})
+	copy(outs.Outputs[pos+1:], outs.Outputs[pos:])
+	outs.Outputs[pos] = out
+
+	outs.Indexes = append(outs.Indexes, 0)
+	copy(outs.Indexes[pos+1:], outs.Indexes[pos:])
+	outs.Indexes[pos] = index
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
+func (outs TxOutputs) Serialize() []byte {
+	return encodeOutputs(outs)
+}
 
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&outputs)
+func DeserializeOutputs(data []byte) TxOutputs {
+	outputs, err := decodeOutputs(data)
 	if err != nil {
 		log.Panic(err)
 	}
 	return outputs
 }
+
+//decodeOutputs is DeserializeOutputs which returns error of broken data
+func decodeOutputs(data []byte) (TxOutputs, error) {
+	return decodeCanonicalOutputs(data)
+}
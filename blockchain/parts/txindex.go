@@ -0,0 +1,94 @@
+package parts
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	txIndexName   = "txindex"
+	txIndexBucket = "txindex"
+)
+
+func init() {
+	indexers[txIndexName] = func() Indexer { return TxIndex{} }
+}
+
+//TxIndex map ID of main chain transaction to hash of its block and position in the block
+//Value is 4 bytes big endian position followed by block hash
+type TxIndex struct{}
+
+//Name of the index
+func (TxIndex) Name() string {
+	return txIndexName
+}
+
+//Init clear the index
+func (TxIndex) Init(tx StorageTx) error {
+	err := tx.DeleteBucket([]byte(txIndexBucket))
+	if err != nil && err != ErrBucketNotFound {
+		return err
+	}
+
+	_, err = tx.CreateBucket([]byte(txIndexBucket))
+	return err
+}
+
+//ConnectBlock index every transaction of block
+func (TxIndex) ConnectBlock(tx StorageTx, block *Block) error {
+	ib := tx.Bucket([]byte(txIndexBucket))
+
+	for i, transaction := range block.Transactions {
+		entry := make([]byte, 4, 4+len(block.Hash))
+		binary.BigEndian.PutUint32(entry, uint32(i))
+		entry = append(entry, block.Hash...)
+
+		err := ib.Put(transaction.ID, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DisconnectBlock remove transactions of block from the index
+func (TxIndex) DisconnectBlock(tx StorageTx, block *Block) error {
+	ib := tx.Bucket([]byte(txIndexBucket))
+
+	for _, transaction := range block.Transactions {
+		err := ib.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//hasTxIndex check transaction index is enabled
+func (bc *BlockChain) hasTxIndex() bool {
+	for _, idx := range bc.indexers {
+		if idx.Name() == txIndexName {
+			return true
+		}
+	}
+	return false
+}
+
+//findIndexedTransaction find block of transaction with transaction index
+func (bc *BlockChain) findIndexedTransaction(ID []byte) (*Block, int, error) {
+	var block *Block
+	var position int
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		entry := tx.Bucket([]byte(txIndexBucket)).Get(ID)
+		if entry == nil {
+			return errors.New("Transation is not found")
+		}
+
+		position = int(binary.BigEndian.Uint32(entry[:4]))
+		block = DeserializeBlock(tx.Bucket([]byte(blocksBucket)).Get(entry[4:]))
+		return nil
+	})
+
+	return block, position, err
+}
@@ -0,0 +1,26 @@
+package parts
+
+import "log"
+
+const undoBucket = "undo"
+
+//BlockUndo keep outputs spent by a block to restore them when the block is disconnected
+//SpentOutputs are ordered same as inputs of non coinbase transactions in the block
+type BlockUndo struct {
+	SpentOutputs []TxOutput
+}
+
+//Serialize BlockUndo to []byte
+func (undo BlockUndo) Serialize() []byte {
+	return encodeUndo(undo)
+}
+
+//DeserializeBlockUndo []byte to BlockUndo
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	undo, err := decodeUndo(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return undo
+}
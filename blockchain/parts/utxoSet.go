@@ -2,9 +2,8 @@ package parts
 
 import (
 	"encoding/hex"
+	"fmt"
 	"log"
-
-	"github.com/boltdb/bolt"
 )
 
 const utxoBucket = "chainstate"
@@ -18,7 +17,7 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amout int) (int, map[st
 	accmulated := 0
 	db := u.BlockChain.Db
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -26,10 +25,10 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amout int) (int, map[st
 			txID := hex.EncodeToString(k)
 			outs := DeserializeOutputs(v)
 
-			for outIdx, out := range outs.Outputs {
+			for i, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubkeyHash) && accmulated < amout {
 					accmulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Index(i))
 				}
 			}
 		}
@@ -46,11 +45,11 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	var UTXOs []TxOutput
 	db := u.BlockChain.Db
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
-		for k, v := c.First(); k != nil; k, _ = c.Next() {
+		for k, v := c.First(); k != nil; k, v = c.Next() {
 			outs := DeserializeOutputs(v)
 
 			for _, out := range outs.Outputs {
@@ -69,13 +68,72 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
 	return UTXOs
 }
 
+//FindOutput return unspent output at index of transaction txID
+func (u UTXOSet) FindOutput(txID []byte, index int) (TxOutput, bool) {
+	var output TxOutput
+	found := false
+	db := u.BlockChain.Db
+
+	err := db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		outsBytes := b.Get(txID)
+		if outsBytes == nil {
+			return nil
+		}
+
+		outs := DeserializeOutputs(outsBytes)
+		for i, out := range outs.Outputs {
+			if outs.Index(i) == index {
+				output = out
+				found = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return output, found
+}
+
+//FindUnspentIndexes return indexes of unspent outputs locked with pubKeyHash by transaction ID
+func (u UTXOSet) FindUnspentIndexes(pubKeyHash []byte) map[string][]int {
+	unspentOutputs := make(map[string][]int)
+	db := u.BlockChain.Db
+
+	err := db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			txID := hex.EncodeToString(k)
+			outs := DeserializeOutputs(v)
+
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Index(i))
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return unspentOutputs
+}
+
 func (u UTXOSet) Reindex() {
 	db := u.BlockChain.Db
 	bucketName := []byte(utxoBucket)
 
-	err := db.Update(func(tx *bolt.Tx) error {
+	err := db.Update(func(tx StorageTx) error {
 		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
+		if err != nil && err != ErrBucketNotFound {
 			log.Panic(err)
 		}
 
@@ -91,7 +149,7 @@ func (u UTXOSet) Reindex() {
 
 	UTXO := u.BlockChain.FindUTXO()
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	err = db.Update(func(tx StorageTx) error {
 		b := tx.Bucket(bucketName)
 
 		for txID, outs := range UTXO {
@@ -110,61 +168,219 @@ func (u UTXOSet) Reindex() {
 	})
 }
 
+//Update apply block to UTXO set and store its undo data
 func (u UTXOSet) Update(block *Block) {
 	db := u.BlockChain.Db
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+	err := db.Update(func(tx StorageTx) error {
+		return u.connectBlock(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TxOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+//Rollback restore UTXO set to the state before block using its undo data
+func (u UTXOSet) Rollback(block *Block) {
+	db := u.BlockChain.Db
+
+	err := db.Update(func(tx StorageTx) error {
+		return u.disconnectBlock(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
+//connectBlock remove outputs spent by block and add its new outputs
+//Transactions are checked against outputs they spend and RuleError is returned for invalid one.
+//Spent outputs are stored in undoBucket with block hash as a key
+func (u UTXOSet) connectBlock(tx StorageTx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	undo := BlockUndo{}
+	fees := 0
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			var spentOutputs []TxOutput
+
+			for _, vin := range tx.Vin {
+				updatedOuts := TxOutputs{}
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return ruleError(ErrMissingInput, "Output %x:%d is not found", vin.Txid, vin.Vout)
+				}
+				outs := DeserializeOutputs(outsBytes)
+				spent := false
+
+				for i, out := range outs.Outputs {
+					if outs.Index(i) == vin.Vout {
+						spentOutputs = append(spentOutputs, out)
+						spent = true
 					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
+						updatedOuts.Add(outs.Index(i), out)
+					}
+				}
+				if !spent {
+					return ruleError(ErrMissingInput, "Output %x:%d is not found", vin.Txid, vin.Vout)
+				}
+
+				if len(updatedOuts.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						log.Panic(err)
+					}
+				} else {
+					err := b.Put(vin.Txid, updatedOuts.Serialize())
+					if err != nil {
+						log.Panic(err)
 					}
 				}
 			}
 
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+			fee, err := checkTransactionInputs(tx, spentOutputs)
+			if err != nil {
+				return err
 			}
+			fees += fee
+			if !moneyRange(fees) {
+				return ruleError(ErrBadTxOutValue, "Fees of block %x sum over %d", block.Hash, maxMoney)
+			}
+			undo.SpentOutputs = append(undo.SpentOutputs, spentOutputs...)
+		}
+
+		//Rollback could not restore overwritten outputs
+		if b.Get(tx.ID) != nil {
+			return ruleError(ErrDuplicateTx, "Transaction %x overwrites unspent outputs", tx.ID)
+		}
+
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.Vout {
+			newOutputs.Add(outIdx, out)
+		}
+
+		err := b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	err := checkCoinbaseValue(block, fees)
+	if err != nil {
+		return err
+	}
+
+	ub, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return ub.Put(block.Hash, undo.Serialize())
+}
+
+//disconnectBlock remove outputs created by block and restore outputs it spent
+//Transactions and inputs are visited in reverse order of connectBlock
+func (u UTXOSet) disconnectBlock(tx StorageTx, block *Block) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	ub := tx.Bucket([]byte(undoBucket))
+
+	var undoData []byte
+	if ub != nil {
+		undoData = ub.Get(block.Hash)
+	}
+	if undoData == nil {
+		return fmt.Errorf("Undo data of block %x is not found", block.Hash)
+	}
+	undo := DeserializeBlockUndo(undoData)
+	spentIdx := len(undo.SpentOutputs)
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		err := b.Delete(tx.ID)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
+		for j := len(tx.Vin) - 1; j >= 0; j-- {
+			vin := tx.Vin[j]
+			spentIdx--
+			if spentIdx < 0 {
+				return fmt.Errorf("Undo data of block %x is corrupted", block.Hash)
+			}
+
+			outs := TxOutputs{}
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+			outs.Add(vin.Vout, undo.SpentOutputs[spentIdx])
+
+			err := b.Put(vin.Txid, outs.Serialize())
 			if err != nil {
 				log.Panic(err)
 			}
 		}
+	}
+
+	return ub.Delete(block.Hash)
+}
+
+//reorganize disconnect blocks from tip to fork point and connect new branch
+//It runs in one db transaction so UTXO set is never left between branches
+func (u UTXOSet) reorganize(tx StorageTx, disconnect, connect []*Block) error {
+	for _, block := range disconnect {
+		err := u.disconnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, block := range connect {
+		err := u.connectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//TotalValue return sum of every unspent output
+func (u UTXOSet) TotalValue() int {
+	db := u.BlockChain.Db
+	total := 0
+
+	err := db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs := DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				total += out.Value
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return total
 }
 
 func (u UTXOSet) CountTransactions() int {
 	db := u.BlockChain.Db
 	counter := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -0,0 +1,61 @@
+package parts
+
+import (
+	"reflect"
+	"testing"
+)
+
+//chainstate return every entry of UTXO bucket
+func chainstate(t *testing.T, bc *BlockChain) map[string]string {
+	entries := make(map[string]string)
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			entries[string(k)] = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+//spendableWallet return wallet whose address is locked to its own pub key hash
+//Base58Encode drops leading zero byte of pub key hash, so outputs to such address cannot be spent by the wallet
+func spendableWallet() *Wallet {
+	for {
+		wallet := NewWallet()
+		if HashPubKey(wallet.PublicKey)[0] != 0 {
+			return wallet
+		}
+	}
+}
+
+//Rollback of a block spending outputs restores chainstate before the block
+func TestRollbackRestoresChainstate(t *testing.T) {
+	wallet := spendableWallet()
+	address := string(wallet.GetAddress())
+	bc := CreateBlockChainWithStorage(address, NewMemoryStorage())
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	before := chainstate(t, bc)
+
+	spend := NewUTXOTransaction(wallet, string(NewWallet().GetAddress()), 3, 1, &utxoSet)
+	block := NewBlock([]*Transaction{NewCoinbaseTx(address, "", 1, 1), spend}, bc.Tip, 1, initialBits)
+
+	utxoSet.Update(block)
+	if reflect.DeepEqual(chainstate(t, bc), before) {
+		t.Fatal("Block does not change chainstate")
+	}
+	if _, ok := utxoSet.FindOutput(spend.Vin[0].Txid, spend.Vin[0].Vout); ok {
+		t.Fatal("Spent output is kept")
+	}
+
+	utxoSet.Rollback(block)
+	if after := chainstate(t, bc); !reflect.DeepEqual(after, before) {
+		t.Fatalf("Chainstate after rollback has %d entries, expected %d", len(after), len(before))
+	}
+}
@@ -0,0 +1,294 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	maxFutureBlockTime = 2 * 60 * 60
+	medianTimeBlocks   = 11
+	//maxMoney is the largest value of an output and of every sum of values, so sums cannot overflow
+	maxMoney = 21000000 * 100000000
+)
+
+//RuleErrorCode identify which consensus rule is broken
+type RuleErrorCode int
+
+//Codes of RuleError
+const (
+	ErrNoTransactions RuleErrorCode = iota
+	ErrBadBlockHash
+	ErrHighHash
+	ErrBadDifficulty
+	ErrTimeTooNew
+	ErrTimeTooOld
+	ErrUnknownParent
+	ErrBadHeight
+	ErrBadMerkleRoot
+	ErrFirstTxNotCoinbase
+	ErrMultipleCoinbases
+	ErrBadCoinbaseValue
+	ErrBadTxID
+	ErrBadTxOutValue
+	ErrDuplicateTx
+	ErrDoubleSpend
+	ErrMissingInput
+	ErrBadSignature
+	ErrSpendTooHigh
+	ErrBadVersion
+)
+
+//RuleError describe why a block is rejected
+type RuleError struct {
+	Code        RuleErrorCode
+	Description string
+}
+
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(code RuleErrorCode, format string, a ...interface{}) RuleError {
+	return RuleError{code, fmt.Sprintf(format, a...)}
+}
+
+//CheckHeaderSanity run context free checks of block header
+//Light client runs it on blocks without transactions
+func CheckHeaderSanity(block *Block) error {
+	if block.Version < blockVersion {
+		return ruleError(ErrBadVersion, "Block %x has version %d", block.Hash, block.Version)
+	}
+
+	pow := NewProofOfWork(block)
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(powLimit) > 0 {
+		return ruleError(ErrBadDifficulty, "Target of block %x is out of range", block.Hash)
+	}
+
+	hash := pow.Hash(block.Nonce)
+	if bytes.Compare(hash, block.Hash) != 0 {
+		return ruleError(ErrBadBlockHash, "Block hash %x does not match header hash %x", block.Hash, hash)
+	}
+	if !pow.Validate() {
+		return ruleError(ErrHighHash, "Block hash %x is higher than target", block.Hash)
+	}
+
+	if block.TimeStamp > time.Now().Unix()+maxFutureBlockTime {
+		return ruleError(ErrTimeTooNew, "Timestamp of block %x is too far in the future", block.Hash)
+	}
+
+	return nil
+}
+
+//CheckBlockSanity run context free checks of block
+func CheckBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "Block %x has no transactions", block.Hash)
+	}
+
+	err := CheckHeaderSanity(block)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Compare(block.HashTransactions(), block.MerkleRoot) != 0 {
+		return ruleError(ErrBadMerkleRoot, "Merkle root of block %x does not match transactions", block.Hash)
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "First transaction of block %x is not coinbase", block.Hash)
+	}
+
+	txIDs := make(map[string]bool)
+	spent := make(map[string]bool)
+
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, "Block %x has more than one coinbase", block.Hash)
+		}
+
+		err := CheckTransactionSanity(tx)
+		if err != nil {
+			return err
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if txIDs[txID] {
+			return ruleError(ErrDuplicateTx, "Transaction %s appears twice in block %x", txID, block.Hash)
+		}
+		txIDs[txID] = true
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			outpoint := outpointKey(vin.Txid, vin.Vout)
+			if spent[outpoint] {
+				return ruleError(ErrDoubleSpend, "Output %s is spent twice in block %x", outpoint, block.Hash)
+			}
+			spent[outpoint] = true
+		}
+	}
+
+	return nil
+}
+
+//CheckTransactionSanity run context free checks of transaction
+func CheckTransactionSanity(tx *Transaction) error {
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return ruleError(ErrMissingInput, "Transaction %x has no inputs or outputs", tx.ID)
+	}
+
+	if !tx.HasValidID() {
+		return ruleError(ErrBadTxID, "ID of transaction %x does not match its contents", tx.ID)
+	}
+
+	//Coinbase output is zero when subsidy has ended and the block has no fee
+	minValue := 1
+	if tx.IsCoinbase() {
+		minValue = 0
+	}
+	totalValue := 0
+	for _, out := range tx.Vout {
+		if out.Value < minValue || out.Value > maxMoney {
+			return ruleError(ErrBadTxOutValue, "Transaction %x has output with value %d", tx.ID, out.Value)
+		}
+		totalValue += out.Value
+		if totalValue > maxMoney {
+			return ruleError(ErrBadTxOutValue, "Outputs of transaction %x sum over %d", tx.ID, maxMoney)
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+	for _, vin := range tx.Vin {
+		if len(vin.Txid) == 0 || vin.Vout < 0 {
+			return ruleError(ErrMissingInput, "Transaction %x has null input", tx.ID)
+		}
+	}
+
+	return nil
+}
+
+//checkBlockContext check block header against its parent
+func checkBlockContext(lookup blockLookup, block, parent *Block) error {
+	if block.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, "Height of block %x is %d, expected %d", block.Hash, block.Height, parent.Height+1)
+	}
+
+	bits := calcNextBits(parent, lookup)
+	if block.Bits != bits {
+		return ruleError(ErrBadDifficulty, "Block %x uses bits %08x, expected %08x", block.Hash, block.Bits, bits)
+	}
+
+	//Miners on the same second produce equal timestamps so only older one is rejected
+	medianTime := medianTimePast(parent, lookup)
+	if block.TimeStamp < medianTime {
+		return ruleError(ErrTimeTooOld, "Timestamp of block %x is before median time %d", block.Hash, medianTime)
+	}
+
+	return nil
+}
+
+//medianTimePast return median timestamp of last medianTimeBlocks blocks ending at block
+func medianTimePast(block *Block, lookup blockLookup) int64 {
+	var timestamps []int64
+
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, block.TimeStamp)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		block = lookup(block.PrevBlockHash)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps)/2]
+}
+
+//checkTransactionInputs check tx against outputs it spends and return its fee
+//spentOutputs are ordered same as tx.Vin
+func checkTransactionInputs(tx *Transaction, spentOutputs []TxOutput) (int, error) {
+	prevTxs := make(map[string]Transaction)
+	inputValue := 0
+
+	for i, vin := range tx.Vin {
+		out := spentOutputs[i]
+		if !vin.UseKey(out.PubKeyHash) {
+			return 0, ruleError(ErrBadSignature, "Input %d of transaction %x uses wrong public key", i, tx.ID)
+		}
+		if !moneyRange(out.Value) {
+			return 0, ruleError(ErrBadTxOutValue, "Input %d of transaction %x spends value %d", i, tx.ID, out.Value)
+		}
+		inputValue += out.Value
+		if !moneyRange(inputValue) {
+			return 0, ruleError(ErrBadTxOutValue, "Inputs of transaction %x sum over %d", tx.ID, maxMoney)
+		}
+		putPrevOutput(prevTxs, vin, out)
+	}
+
+	if !tx.Verify(prevTxs) {
+		return 0, ruleError(ErrBadSignature, "Signature of transaction %x is invalid", tx.ID)
+	}
+
+	outputValue := 0
+	for _, out := range tx.Vout {
+		outputValue += out.Value
+		if !moneyRange(out.Value) || !moneyRange(outputValue) {
+			return 0, ruleError(ErrBadTxOutValue, "Outputs of transaction %x sum over %d", tx.ID, maxMoney)
+		}
+	}
+	if outputValue > inputValue {
+		return 0, ruleError(ErrSpendTooHigh, "Transaction %x spends %d but has only %d", tx.ID, outputValue, inputValue)
+	}
+
+	//Fee is implicit. Miner takes what is not spent by outputs
+	return inputValue - outputValue, nil
+}
+
+//putPrevOutput put output referenced by vin to prevTxs
+//Sign and Verify only need outputs which are referenced by inputs
+func putPrevOutput(prevTxs map[string]Transaction, vin TxInput, out TxOutput) {
+	txID := hex.EncodeToString(vin.Txid)
+	prevTx := prevTxs[txID]
+	prevTx.ID = vin.Txid
+	for len(prevTx.Vout) <= vin.Vout {
+		prevTx.Vout = append(prevTx.Vout, TxOutput{})
+	}
+	prevTx.Vout[vin.Vout] = out
+	prevTxs[txID] = prevTx
+}
+
+//checkCoinbaseValue check coinbase does not claim more than scheduled subsidy and fees of the block
+func checkCoinbaseValue(block *Block, fees int) error {
+	value := 0
+	for _, out := range block.Transactions[0].Vout {
+		value += out.Value
+		if !moneyRange(out.Value) || !moneyRange(value) {
+			return ruleError(ErrBadCoinbaseValue, "Coinbase of block %x claims over %d", block.Hash, maxMoney)
+		}
+	}
+
+	subsidy := Emission.Subsidy(block.Height)
+	if !moneyRange(subsidy) || !moneyRange(fees) {
+		return ruleError(ErrBadCoinbaseValue, "Subsidy %d or fees %d of block %x are out of range", subsidy, fees, block.Hash)
+	}
+	allowed := subsidy + fees
+	if value > allowed {
+		return ruleError(ErrBadCoinbaseValue, "Coinbase of block %x claims %d, allowed %d", block.Hash, value, allowed)
+	}
+
+	return nil
+}
+
+//moneyRange report whether value is an amount of coins which can be added to another one without overflow
+func moneyRange(value int) bool {
+	return value >= 0 && value <= maxMoney
+}
@@ -0,0 +1,234 @@
+package parts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+//Levels of VerifyChain. Each level includes checks of lower levels
+const (
+	VerifyLinks      = iota //blocks are readable, linked by PrevBlockHash and heights are continuous
+	VerifyBlocks            //proof of work, merkle root and transaction sanity of every block
+	VerifySignatures        //signature of every input
+	VerifyUTXO              //chainstate bucket equals UTXO computed from blocks
+)
+
+//VerifyChain walk from the tip to genesis and return every discrepancy found
+//Stored data is read without trusting it, so a broken db is reported instead of panic
+//Only headers of pruned blocks are checked, and UTXO set of pruned chain is not compared
+//Blocks migrated from gob encoding are trusted except their links
+func (bc *BlockChain) VerifyChain(level int) []string {
+	var problems []string
+	report := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	blocks := bc.verifyLinks(report)
+	linksBroken := len(problems) > 0
+	if level < VerifyBlocks {
+		return problems
+	}
+
+	pruned := false
+	for _, block := range blocks {
+		var err error
+		if block.Height <= bc.legacyHeight {
+			pruned = pruned || block.IsPruned()
+			continue
+		}
+		if block.IsPruned() {
+			pruned = true
+			err = CheckHeaderSanity(block)
+		} else {
+			err = CheckBlockSanity(block)
+		}
+		if err != nil {
+			report("Block %x at height %d: %s", block.Hash, block.Height, err)
+		}
+	}
+	if level < VerifySignatures {
+		return problems
+	}
+
+	verifySignatures(blocks, pruned, bc.legacyHeight, report)
+	if level < VerifyUTXO || pruned {
+		return problems
+	}
+
+	//FindUTXO walks the chain again, so links must be sound
+	if linksBroken {
+		report("UTXO set is not compared because blocks have problems")
+		return problems
+	}
+	bc.verifyUTXO(report)
+
+	return problems
+}
+
+//verifyLinks return readable main chain blocks from the tip
+//Walk stops at a block seen before, so corrupted links cannot loop forever
+func (bc *BlockChain) verifyLinks(report func(string, ...interface{})) []*Block {
+	var blocks []*Block
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		hb := tx.Bucket([]byte(heightBucket))
+		if b == nil || hb == nil {
+			report("Blocks or heights bucket is missing")
+			return nil
+		}
+
+		hash := b.Get([]byte("l"))
+		if hash == nil {
+			report("Tip is not stored")
+			return nil
+		}
+
+		var child *Block
+		visited := make(map[string]bool)
+		for len(hash) > 0 {
+			if visited[string(hash)] {
+				report("Block %x links back to its descendant", hash)
+				break
+			}
+			visited[string(hash)] = true
+
+			data := b.Get(hash)
+			if data == nil {
+				report("Block %x is missing", hash)
+				break
+			}
+			block, err := decodeBlock(data)
+			if err != nil {
+				report("Block %x cannot be decoded: %s", hash, err)
+				break
+			}
+
+			if !bytes.Equal(block.Hash, hash) {
+				report("Block stored at %x has hash %x", hash, block.Hash)
+			}
+			if child != nil && block.Height+1 != child.Height {
+				report("Block %x has height %d but its child has height %d", block.Hash, block.Height, child.Height)
+			}
+			if indexed := hb.Get(heightKey(block.Height)); !bytes.Equal(indexed, block.Hash) {
+				report("Height index has %x at height %d instead of %x", indexed, block.Height, block.Hash)
+			}
+
+			blocks = append(blocks, block)
+			child = block
+			hash = block.PrevBlockHash
+		}
+
+		if child != nil && len(child.PrevBlockHash) == 0 && child.Height != 0 {
+			report("Genesis block %x has height %d", child.Hash, child.Height)
+		}
+		return nil
+	})
+	if err != nil {
+		report("Cannot read storage: %s", err)
+	}
+
+	return blocks
+}
+
+//verifySignatures check inputs of every transaction with transactions of blocks
+//Inputs spending transactions of pruned blocks cannot be checked, and blocks up to legacyHeight were signed over gob
+func verifySignatures(blocks []*Block, pruned bool, legacyHeight int, report func(string, ...interface{})) {
+	txs := make(map[string]Transaction)
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			txs[hex.EncodeToString(tx.ID)] = *tx
+		}
+	}
+
+	for _, block := range blocks {
+		if block.Height <= legacyHeight {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+
+			prevTxs := make(map[string]Transaction)
+			complete := true
+			for _, vin := range tx.Vin {
+				prevTx, ok := txs[hex.EncodeToString(vin.Txid)]
+				if !ok && pruned {
+					complete = false
+					continue
+				}
+				if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+					report("Transaction %x in block %x spends unknown output %x:%d", tx.ID, block.Hash, vin.Txid, vin.Vout)
+					complete = false
+					continue
+				}
+				prevTxs[hex.EncodeToString(vin.Txid)] = prevTx
+			}
+
+			if complete && !tx.Verify(prevTxs) {
+				report("Transaction %x in block %x has invalid signature", tx.ID, block.Hash)
+			}
+		}
+	}
+}
+
+//verifyUTXO compare chainstate bucket with UTXO computed from blocks
+func (bc *BlockChain) verifyUTXO(report func(string, ...interface{})) {
+	expected := bc.FindUTXO()
+	seen := make(map[string]bool)
+
+	err := bc.Db.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		if b == nil {
+			report("Chainstate bucket is missing")
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			txID := hex.EncodeToString(k)
+			seen[txID] = true
+
+			stored, err := decodeOutputs(v)
+			if err != nil {
+				report("Outputs of %s in chainstate cannot be decoded: %s", txID, err)
+				continue
+			}
+
+			outs, ok := expected[txID]
+			if !ok {
+				report("Chainstate has outputs of %s which are spent or unknown", txID)
+				continue
+			}
+			if !sameOutputs(stored, outs) {
+				report("Chainstate outputs of %s differ from blocks", txID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		report("Cannot read storage: %s", err)
+	}
+
+	for txID := range expected {
+		if !seen[txID] {
+			report("Chainstate misses unspent outputs of %s", txID)
+		}
+	}
+}
+
+func sameOutputs(a, b TxOutputs) bool {
+	if len(a.Outputs) != len(b.Outputs) {
+		return false
+	}
+
+	for i := range a.Outputs {
+		if a.Index(i) != b.Index(i) || a.Outputs[i].Value != b.Outputs[i].Value ||
+			!bytes.Equal(a.Outputs[i].PubKeyHash, b.Outputs[i].PubKeyHash) {
+			return false
+		}
+	}
+	return true
+}
@@ -15,12 +15,12 @@ type Wallets struct {
 	Wallets map[string]*Wallet
 }
 
-//NewWallets generate new wallets
-func NewWallets(nodeID string) (*Wallets, error) {
+//NewWallets load wallets of data directory
+func NewWallets(dir DataDir) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 
-	err := wallets.LoadFromFile(nodeID)
+	err := wallets.LoadFromFile(dir)
 
 	return &wallets, err
 }
@@ -51,7 +51,8 @@ func (ws Wallets) GetWallet(address string) Wallet {
 }
 
 //LoadFromFile from .dat file
-func (ws *Wallets) LoadFromFile(nodeID string) error {
+func (ws *Wallets) LoadFromFile(dir DataDir) error {
+	walletFile := dir.WalletFile()
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -74,9 +75,9 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 }
 
 //SaveToFile save file to .dat
-func (ws Wallets) SaveToFile(nodeID string) {
+func (ws Wallets) SaveToFile(dir DataDir) {
 	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	walletFile := dir.WalletFile()
 	gob.Register(elliptic.P256())
 
 	encoder := gob.NewEncoder(&content)